@@ -0,0 +1,53 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPアドレスとして保存する最大の長さ（IPv6 の表記の最大長）
+const maxLength = 45
+
+// Resolver はリクエスト元のIPアドレスを求める
+// ゼロ値は X-Forwarded-For を使わず、接続元のアドレスをそのまま使う
+type Resolver struct {
+	// アプリケーションの前段にある、X-Forwarded-For に接続元を追記するプロキシの数
+	// X-Forwarded-For の先頭側はクライアントが自由に書けるため、信頼できるプロキシが追記した末尾側の値のみを使う
+	TrustedProxyHops int
+}
+
+func New(trustedProxyHops int) Resolver {
+	return Resolver{TrustedProxyHops: trustedProxyHops}
+}
+
+// IP はリクエスト元のIPアドレスを返す
+// プロキシ経由の場合は、X-Forwarded-For の末尾から TrustedProxyHops 番目（最も外側のプロキシが追記した値）を使う
+func (c Resolver) IP(r *http.Request) string {
+	if c.TrustedProxyHops > 0 {
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(header, ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					entries = append(entries, entry)
+				}
+			}
+		}
+		if len(entries) >= c.TrustedProxyHops {
+			return truncate(entries[len(entries)-c.TrustedProxyHops])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return truncate(r.RemoteAddr)
+	}
+	return host
+}
+
+func truncate(s string) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	return s[:maxLength]
+}
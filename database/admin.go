@@ -0,0 +1,30 @@
+package database
+
+import "gorm.io/gorm"
+
+// BootstrapAdmins は管理者がまだいない場合に限り、emails のユーザーのうちメールアドレスを確認済みのユーザーを管理者にする
+// 最初の管理者を用意するためのもので、管理者が1人でもいれば何もしない（以降は setUserRole で管理する）
+// 未確認のユーザーを対象にしないのは、管理者のメールアドレスを先に登録しただけで管理者になれないようにするため
+func BootstrapAdmins(db *gorm.DB, emails []string) (int64, error) {
+	if len(emails) == 0 {
+		return 0, nil
+	}
+
+	var promoted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var adminCount int64
+		if err := tx.Model(&User{}).Where("role = ?", RoleAdmin).Count(&adminCount).Error; err != nil {
+			return err
+		}
+		if adminCount > 0 {
+			return nil
+		}
+
+		result := tx.Model(&User{}).
+			Where("email IN ? AND verified_at IS NOT NULL AND disabled_at IS NULL", emails).
+			Update("role", RoleAdmin)
+		promoted = result.RowsAffected
+		return result.Error
+	})
+	return promoted, err
+}
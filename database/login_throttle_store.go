@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/suimi34/golang-graphql/lockout"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginThrottleStore はログイン失敗の記録を login_throttles テーブルに保存する lockout.Store の実装
+// 複数のレプリカで同じデータベースを使えば、どのレプリカへの試行も同じ回数として数えられる
+type LoginThrottleStore struct {
+	DB *gorm.DB
+}
+
+var _ lockout.Store = (*LoginThrottleStore)(nil)
+
+func NewLoginThrottleStore(db *gorm.DB) *LoginThrottleStore {
+	return &LoginThrottleStore{DB: db}
+}
+
+func (s *LoginThrottleStore) Get(ctx context.Context, key string) (lockout.Record, error) {
+	var row LoginThrottle
+	err := s.DB.WithContext(ctx).Where("throttle_key = ?", key).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return lockout.Record{}, nil
+	}
+	if err != nil {
+		return lockout.Record{}, err
+	}
+	return row.record(), nil
+}
+
+// Fail は行ロックを取って失敗回数を更新する
+// 行がまだない場合は先に作成しておく（同時に作成されても主キーの重複は無視する）
+func (s *LoginThrottleStore) Fail(ctx context.Context, key string, now time.Time, policy lockout.Policy) (lockout.Record, error) {
+	var record lockout.Record
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&LoginThrottle{ThrottleKey: key, LastFailedAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		var row LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("throttle_key = ?", key).First(&row).Error; err != nil {
+			return err
+		}
+
+		record = policy.Apply(row.record(), now)
+
+		updates := map[string]interface{}{
+			"failures":       record.Failures,
+			"last_failed_at": record.LastFailedAt,
+			"locked_until":   nil,
+		}
+		if !record.LockedUntil.IsZero() {
+			updates["locked_until"] = record.LockedUntil
+		}
+		return tx.Model(&LoginThrottle{}).Where("throttle_key = ?", key).Updates(updates).Error
+	})
+	if err != nil {
+		return lockout.Record{}, err
+	}
+	return record, nil
+}
+
+func (s *LoginThrottleStore) Reset(ctx context.Context, key string) error {
+	return s.DB.WithContext(ctx).Where("throttle_key = ?", key).Delete(&LoginThrottle{}).Error
+}
+
+func (t LoginThrottle) record() lockout.Record {
+	record := lockout.Record{
+		Failures:     t.Failures,
+		LastFailedAt: t.LastFailedAt,
+	}
+	if t.LockedUntil != nil {
+		record.LockedUntil = *t.LockedUntil
+	}
+	return record
+}
@@ -1,22 +1,43 @@
 package database
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
 	"time"
+
+	"github.com/suimi34/golang-graphql/clientip"
 )
 
 // User represents the users table
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"not null" json:"name"`
-	Email     string    `gorm:"uniqueIndex;not null" json:"email"`
-	Password  string    `gorm:"not null" json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Email      string     `gorm:"uniqueIndex;not null" json:"email"`
+	Password   string     `gorm:"not null" json:"-"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	// 認証アプリに登録したTOTPのシークレット（確認前は TwoFactorEnabledAt が nil）
+	TwoFactorSecret    *string    `gorm:"size:64" json:"-"`
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"`
+	// 最後に受け付けたTOTPの時間ステップ（同じ確認コードを再利用させないために使う）
+	TwoFactorLastStep *int64 `json:"-"`
+	Role              string `gorm:"size:16;not null;default:user" json:"role"`
+	// 管理者が無効化した日時（無効化されたユーザーはログインできない）
+	DisabledAt *time.Time `json:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 
 	// Relations
 	Todos []Todo `gorm:"foreignKey:UserID" json:"todos,omitempty"`
 }
 
+// ユーザーのロール
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // Todo represents the todos table
 type Todo struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -29,3 +50,192 @@ type Todo struct {
 	// Relations
 	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
+
+// Session represents the sessions table
+type Session struct {
+	ID         string    `gorm:"primaryKey;size:64" json:"-"`
+	UserID     *uint     `gorm:"index" json:"user_id"`
+	Data       string    `gorm:"type:text;not null" json:"-"`
+	IPAddress  string    `gorm:"size:45;not null" json:"ip_address"`
+	UserAgent  string    `gorm:"size:512;not null" json:"user_agent"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `gorm:"index;not null" json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PublicID はセッションIDを推測できない形で外部に公開するための識別子を返す
+func (s Session) PublicID() string {
+	sum := sha256.Sum256([]byte(s.ID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// PasswordResetToken represents the password_reset_tokens table
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// EmailVerificationToken represents the email_verification_tokens table
+type EmailVerificationToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// APIToken represents the api_tokens table
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Scopes     string     `gorm:"size:255;not null" json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ScopeList はカンマ区切りで保存しているスコープを分割して返す
+func (t APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// RefreshToken represents the refresh_tokens table
+// 同じログインから発行されたトークンは FamilyID を共有し、再利用を検知した場合はまとめて失効させる
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID  string     `gorm:"size:64;not null;index" json:"-"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// EmailChangeToken represents the email_change_tokens table
+type EmailChangeToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	NewEmail  string     `gorm:"size:255;not null" json:"new_email"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// UserIdentity represents the user_identities table
+// 外部のOpenID Connectプロバイダーのアカウントとユーザーを紐付ける
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Provider  string    `gorm:"size:50;not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject   string    `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject" json:"subject"`
+	Email     string    `gorm:"not null" json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TwoFactorRecoveryCode represents the two_factor_recovery_codes table
+type TwoFactorRecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TwoFactorChallenge represents the two_factor_challenges table
+// パスワードの確認が済み、2段階認証の確認コードを待っているログイン
+type TwoFactorChallenge struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Attempts  int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginThrottle represents the login_throttles table
+type LoginThrottle struct {
+	ThrottleKey  string     `gorm:"primaryKey;size:320" json:"throttle_key"`
+	Failures     int        `gorm:"not null;default:0" json:"failures"`
+	LastFailedAt time.Time  `gorm:"not null" json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// LoginAttempt represents the login_attempts table
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Email     string    `gorm:"size:255;not null;index" json:"email"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	IPAddress string    `gorm:"size:45" json:"ip_address"`
+	UserAgent string    `gorm:"size:512" json:"user_agent"`
+	Reason    string    `gorm:"size:32;not null" json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AccountUnlockToken represents the account_unlock_tokens table
+type AccountUnlockToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// DataExportToken represents the data_export_tokens table
+type DataExportToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AuditLog represents the audit_logs table
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Action    string    `gorm:"size:64;not null;index" json:"action"`
+	IPAddress string    `gorm:"size:45" json:"ip_address"`
+	UserAgent string    `gorm:"size:512" json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 監査記録に残す操作
+const (
+	AuditActionDataExportRequested  = "data_export_requested"
+	AuditActionDataExportDownloaded = "data_export_downloaded"
+	AuditActionAccountDeleted       = "account_deleted"
+)
+
+// NewAuditLog はリクエスト元の情報を付けた監査記録を作成する
+// リクエスト元のIPアドレスは clientIP で求める
+func NewAuditLog(clientIP clientip.Resolver, r *http.Request, userID uint, action string) AuditLog {
+	entry := AuditLog{UserID: userID, Action: action}
+	if r != nil {
+		entry.IPAddress = clientIP.IP(r)
+		entry.UserAgent = truncate(r.UserAgent(), 512)
+	}
+	return entry
+}
+
+// HashToken はデータベースに保存するトークンのハッシュ値を返す（平文は保存しない）
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
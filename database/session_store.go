@@ -0,0 +1,271 @@
+package database
+
+import (
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/suimi34/golang-graphql/clientip"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var base32RawStdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 最終アクセス日時を更新する間隔
+const lastSeenInterval = time.Minute
+
+// SessionStore はセッションの内容を sessions テーブルに保存する sessions.Store の実装
+// Cookieにはセッションの内容ではなく署名済みのセッションIDのみを保存するため、
+// 行を削除すればサーバー側からセッションを無効化できる
+type SessionStore struct {
+	DB      *gorm.DB
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	// セッションに記録するリクエスト元のIPアドレスの求め方
+	ClientIP clientip.Resolver
+}
+
+var _ sessions.Store = (*SessionStore)(nil)
+
+func NewSessionStore(db *gorm.DB, keyPairs ...[]byte) *SessionStore {
+	s := &SessionStore{
+		DB:     db,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// Get はリクエスト内で共有されるセッションを返す
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New はCookieのセッションIDに対応するセッションをデータベースから読み込む
+// 行が存在しない・期限切れの場合は新しいセッションとして扱う
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		session.ID = ""
+		return session, nil
+	}
+
+	session.IsNew = false
+	return session, nil
+}
+
+// Save はセッションをデータベースに保存し、セッションIDをCookieに書き込む
+// Options.MaxAge が0以下の場合は行を削除してCookieを失効させる
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if err := s.erase(session); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+	if err := s.save(r, session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// MaxAge はストアと署名に使うsecurecookieの有効期限を設定する
+func (s *SessionStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Regenerate はセッションの行を削除し、次の Save で新しいセッションIDを発行させる
+// ログイン前のセッションIDを引き継がないよう、ログインした時に呼ぶ（セッション固定攻撃の対策）
+func (s *SessionStore) Regenerate(session *sessions.Session) error {
+	if err := s.erase(session); err != nil {
+		return err
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// StartUserSession はユーザーのログインセッションを新しいセッションIDで作成し、Cookieを発行する
+// パスワード・2段階認証・OpenID Connect のどの方法でログインした場合もこれを使う
+func StartUserSession(store sessions.Store, r *http.Request, w http.ResponseWriter, dbUser User) error {
+	session, _ := store.Get(r, "session")
+	if regenerator, ok := store.(interface {
+		Regenerate(session *sessions.Session) error
+	}); ok {
+		if err := regenerator.Regenerate(session); err != nil {
+			return err
+		}
+	}
+
+	// ログイン前にセッションに保存された値は引き継がない
+	session.Values = map[interface{}]interface{}{
+		"user_id": dbUser.ID,
+		"email":   dbUser.Email,
+	}
+	return session.Save(r, w)
+}
+
+// DeleteExpired は期限切れのセッションを削除する
+func (s *SessionStore) DeleteExpired() error {
+	return s.DB.Where("expires_at <= ?", time.Now()).Delete(&Session{}).Error
+}
+
+// StartCleanup は interval ごとに期限切れのセッションを削除する
+// 返り値の関数を呼ぶと停止する
+func (s *SessionStore) StartCleanup(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.DeleteExpired(); err != nil {
+					log.Printf("期限切れセッションの削除に失敗: %v", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// UpdateUserSessions はユーザーの有効なセッションすべての内容を update で書き換えて保存する
+// セッションに保存しているユーザーの情報（メールアドレスなど）が変わった時に使う
+func (s *SessionStore) UpdateUserSessions(name string, userID uint, update func(values map[interface{}]interface{})) error {
+	var rows []Session
+	if err := s.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Find(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		values := map[interface{}]interface{}{}
+		if err := securecookie.DecodeMulti(name, row.Data, &values, s.Codecs...); err != nil {
+			// 別の名前のセッションや署名鍵を変更する前のセッションは対象外
+			continue
+		}
+		update(values)
+
+		encoded, err := securecookie.EncodeMulti(name, values, s.Codecs...)
+		if err != nil {
+			return err
+		}
+		if err := s.DB.Model(&Session{}).Where("id = ?", row.ID).UpdateColumn("data", encoded).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save はセッションの内容とリクエスト元の情報を保存する
+func (s *SessionStore) save(r *http.Request, session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	row := Session{
+		ID:         session.ID,
+		Data:       encoded,
+		IPAddress:  s.ClientIP.IP(r),
+		UserAgent:  truncate(r.UserAgent(), 512),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+	if userID, ok := session.Values["user_id"].(uint); ok {
+		row.UserID = &userID
+	}
+
+	// 作成日時は残したまま内容と有効期限を更新
+	return s.DB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "data", "ip_address", "user_agent", "last_seen_at", "expires_at", "updated_at"}),
+	}).Create(&row).Error
+}
+
+// load はセッションの内容を読み込む。有効な行が存在しない場合は false を返す
+func (s *SessionStore) load(session *sessions.Session) (bool, error) {
+	var row Session
+	err := s.DB.Where("id = ? AND expires_at > ?", session.ID, time.Now()).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := securecookie.DecodeMulti(session.Name(), row.Data, &session.Values, s.Codecs...); err != nil {
+		return false, err
+	}
+
+	// 最終アクセス日時は書き込みを減らすため一定間隔でのみ更新
+	if time.Since(row.LastSeenAt) > lastSeenInterval {
+		if err := s.DB.Model(&Session{}).Where("id = ?", row.ID).UpdateColumn("last_seen_at", time.Now()).Error; err != nil {
+			log.Printf("セッションの最終アクセス日時の更新に失敗: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// erase はセッションの行を削除する
+func (s *SessionStore) erase(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	return s.DB.Where("id = ?", session.ID).Delete(&Session{}).Error
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
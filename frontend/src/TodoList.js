@@ -1,17 +1,28 @@
-import { useState, useEffect } from 'react';
+import { useState, useEffect, useRef, useCallback } from 'react';
 import { GraphQLClient, gql } from 'graphql-request';
 
 const client = new GraphQLClient('/query');
 
+const PAGE_SIZE = 20;
+
 const GET_TODOS_QUERY = gql`
-  query GetTodos {
-    todos {
-      id
-      text
-      done
-      user {
-        id
-        name
+  query GetTodos($first: Int, $after: String) {
+    todosConnection(first: $first, after: $after) {
+      edges {
+        cursor
+        node {
+          id
+          text
+          done
+          user {
+            id
+            name
+          }
+        }
+      }
+      pageInfo {
+        hasNextPage
+        endCursor
       }
     }
   }
@@ -31,22 +42,125 @@ const CREATE_TODO_MUTATION = gql`
   }
 `;
 
+const ME_QUERY = gql`
+  query Me {
+    me {
+      id
+      name
+    }
+  }
+`;
+
+const TODO_CHANGED_SUBSCRIPTION = gql`
+  subscription TodoChanged {
+    todoChanged {
+      type
+      todoId
+      todo {
+        id
+        text
+        done
+        user {
+          id
+          name
+        }
+      }
+    }
+  }
+`;
+
+const LOGOUT_USER_MUTATION = gql`
+  mutation LogoutUser {
+    logoutUser {
+      success
+      message
+    }
+  }
+`;
+
 const TodoList = () => {
   const [todos, setTodos] = useState([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState('');
   const [newTodoText, setNewTodoText] = useState('');
   const [creating, setCreating] = useState(false);
+  const [pageInfo, setPageInfo] = useState({ hasNextPage: false, endCursor: null });
+  const [loadingMore, setLoadingMore] = useState(false);
+  const sentinelRef = useRef(null);
+  const [currentUser, setCurrentUser] = useState(null);
 
   useEffect(() => {
+    fetchCurrentUser();
     fetchTodos();
   }, []);
 
+  // 他のタブや端末での変更を WebSocket（graphql-transport-ws）で受け取って一覧に反映する
+  useEffect(() => {
+    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const socket = new WebSocket(`${protocol}//${window.location.host}/query`, 'graphql-transport-ws');
+
+    socket.onopen = () => {
+      // 認証はセッションCookieで行う
+      socket.send(JSON.stringify({ type: 'connection_init' }));
+    };
+    socket.onmessage = (e) => {
+      const message = JSON.parse(e.data);
+      switch (message.type) {
+        case 'connection_ack':
+          socket.send(JSON.stringify({
+            id: 'todoChanged',
+            type: 'subscribe',
+            payload: { query: TODO_CHANGED_SUBSCRIPTION }
+          }));
+          break;
+        case 'ping':
+          socket.send(JSON.stringify({ type: 'pong' }));
+          break;
+        case 'next':
+          if (message.payload.data) {
+            applyTodoChange(message.payload.data.todoChanged);
+          }
+          break;
+        default:
+          break;
+      }
+    };
+
+    return () => socket.close();
+  }, []);
+
+  const applyTodoChange = (event) => {
+    setTodos((prev) => {
+      switch (event.type) {
+        case 'CREATED':
+          // 自分で追加したTodoはレスポンスで追加済みの場合がある
+          return prev.some((todo) => todo.id === event.todoId) ? prev : [event.todo, ...prev];
+        case 'UPDATED':
+          return prev.map((todo) => (todo.id === event.todoId ? event.todo : todo));
+        case 'DELETED':
+          return prev.filter((todo) => todo.id !== event.todoId);
+        default:
+          return prev;
+      }
+    });
+  };
+
+  const fetchCurrentUser = async () => {
+    try {
+      const response = await client.request(ME_QUERY);
+      setCurrentUser(response.me);
+    } catch (err) {
+      console.error('Error fetching current user:', err);
+    }
+  };
+
   const fetchTodos = async () => {
     try {
       setLoading(true);
-      const response = await client.request(GET_TODOS_QUERY);
-      setTodos(response.todos || []);
+      const response = await client.request(GET_TODOS_QUERY, { first: PAGE_SIZE });
+      const connection = response.todosConnection;
+      setTodos(connection.edges.map((edge) => edge.node));
+      setPageInfo(connection.pageInfo);
       setError('');
     } catch (err) {
       console.error('Error fetching todos:', err);
@@ -56,6 +170,44 @@ const TodoList = () => {
     }
   };
 
+  // 末尾までスクロールしたら次のページを読み込む
+  const fetchMoreTodos = useCallback(async () => {
+    if (loadingMore || !pageInfo.hasNextPage) {
+      return;
+    }
+
+    try {
+      setLoadingMore(true);
+      const response = await client.request(GET_TODOS_QUERY, {
+        first: PAGE_SIZE,
+        after: pageInfo.endCursor
+      });
+      const connection = response.todosConnection;
+      setTodos((prev) => [...prev, ...connection.edges.map((edge) => edge.node)]);
+      setPageInfo(connection.pageInfo);
+    } catch (err) {
+      console.error('Error fetching more todos:', err);
+      setError('Todoの取得に失敗しました');
+    } finally {
+      setLoadingMore(false);
+    }
+  }, [loadingMore, pageInfo]);
+
+  useEffect(() => {
+    const sentinel = sentinelRef.current;
+    if (!sentinel) {
+      return undefined;
+    }
+
+    const observer = new IntersectionObserver((entries) => {
+      if (entries[0].isIntersecting) {
+        fetchMoreTodos();
+      }
+    });
+    observer.observe(sentinel);
+    return () => observer.disconnect();
+  }, [fetchMoreTodos]);
+
   const handleCreateTodo = async (e) => {
     e.preventDefault();
     if (!newTodoText.trim()) {
@@ -70,7 +222,9 @@ const TodoList = () => {
           text: newTodoText
         }
       });
-      setTodos([response.createTodo, ...todos]);
+      // サブスクリプションで先に追加されている場合は重複させない
+      const created = response.createTodo;
+      setTodos((prev) => (prev.some((todo) => todo.id === created.id) ? prev : [created, ...prev]));
       setNewTodoText('');
       setError('');
     } catch (err) {
@@ -81,6 +235,22 @@ const TodoList = () => {
     }
   };
 
+  const handleLogout = async (e) => {
+    e.preventDefault();
+    try {
+      await client.request(LOGOUT_USER_MUTATION);
+      window.location.href = '/login';
+    } catch (err) {
+      console.error('Error logging out:', err);
+      // ミューテーションに失敗した場合はログアウトルートにPOSTしてセッションを破棄する
+      const form = document.createElement('form');
+      form.method = 'POST';
+      form.action = '/logout';
+      document.body.appendChild(form);
+      form.submit();
+    }
+  };
+
   const styles = {
     container: {
       maxWidth: '800px',
@@ -112,6 +282,11 @@ const TodoList = () => {
       borderRadius: '4px',
       fontSize: '14px'
     },
+    userName: {
+      alignSelf: 'center',
+      color: '#555',
+      fontSize: '14px'
+    },
     logoutLink: {
       padding: '8px 16px',
       backgroundColor: '#dc3545',
@@ -217,7 +392,8 @@ const TodoList = () => {
       <header style={styles.header}>
         <h1 style={styles.title}>📝 Todo一覧</h1>
         <nav style={styles.nav}>
-          <a href="/login" style={styles.logoutLink}>ログアウト</a>
+          {currentUser && <span style={styles.userName}>{currentUser.name} さん</span>}
+          <a href="/logout" onClick={handleLogout} style={styles.logoutLink}>ログアウト</a>
         </nav>
       </header>
 
@@ -269,6 +445,12 @@ const TodoList = () => {
           ))}
         </ul>
       )}
+
+      {!loading && pageInfo.hasNextPage && (
+        <div ref={sentinelRef} style={styles.loadingMessage}>
+          {loadingMore ? '読み込み中...' : ''}
+        </div>
+      )}
     </div>
   );
 };
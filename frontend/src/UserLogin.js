@@ -8,6 +8,8 @@ const LOGIN_USER_MUTATION = gql`
     loginUser(input: $input) {
       success
       message
+      twoFactorRequired
+      loginToken
       user {
         id
         name
@@ -18,15 +20,59 @@ const LOGIN_USER_MUTATION = gql`
   }
 `;
 
+const VERIFY_TWO_FACTOR_MUTATION = gql`
+  mutation VerifyTwoFactor($loginToken: String!, $code: String!) {
+    verifyTwoFactor(loginToken: $loginToken, code: $code) {
+      success
+      message
+      user {
+        id
+        name
+        email
+        createdAt
+      }
+    }
+  }
+`;
+
+// サーバーが埋め込んだSSOプロバイダーの一覧（[{Name, DisplayName}]）
+const readOIDCProviders = () => {
+  const element = document.getElementById('oidc-providers');
+  if (!element) {
+    return [];
+  }
+  try {
+    return JSON.parse(element.textContent) || [];
+  } catch (error) {
+    return [];
+  }
+};
+
+// SSOでログインした2段階認証が有効なユーザーは、確認コード待ちのトークンを付けて戻ってくる（#two_factor=...）
+const readPendingLoginToken = () => {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const token = params.get('two_factor');
+  if (token) {
+    // 再読み込みや履歴にトークンを残さない
+    window.history.replaceState(null, '', window.location.pathname + window.location.search);
+  }
+  return token;
+};
+
 const UserLogin = () => {
+  const [oidcProviders] = useState(readOIDCProviders);
+  const [pendingLoginToken] = useState(readPendingLoginToken);
   const [formData, setFormData] = useState({
     email: '',
     password: ''
   });
   const [loading, setLoading] = useState(false);
-  const [message, setMessage] = useState('');
+  const [message, setMessage] = useState(pendingLoginToken ? '認証アプリに表示されている確認コードを入力してください' : '');
   const [isSuccess, setIsSuccess] = useState(false);
   const [loggedInUser, setLoggedInUser] = useState(null);
+  // 2段階認証の確認コード待ちの場合に設定される
+  const [loginToken, setLoginToken] = useState(pendingLoginToken);
+  const [twoFactorCode, setTwoFactorCode] = useState('');
 
   const handleChange = (e) => {
     const { name, value } = e.target;
@@ -60,13 +106,11 @@ const UserLogin = () => {
       const response = await client.request(LOGIN_USER_MUTATION, variables);
 
       if (response.loginUser.success) {
+        completeLogin(response.loginUser);
+      } else if (response.loginUser.twoFactorRequired) {
+        setLoginToken(response.loginUser.loginToken);
         setMessage(response.loginUser.message);
-        setIsSuccess(true);
-        setLoggedInUser(response.loginUser.user);
-        // ログイン成功後、Todo一覧ページにリダイレクト
-        setTimeout(() => {
-          window.location.href = '/todos';
-        }, 1500);
+        setIsSuccess(false);
       } else {
         setMessage(response.loginUser.message);
         setIsSuccess(false);
@@ -80,6 +124,42 @@ const UserLogin = () => {
     }
   };
 
+  const completeLogin = (result) => {
+    setMessage(result.message);
+    setIsSuccess(true);
+    setLoggedInUser(result.user);
+    // ログイン成功後、Todo一覧ページにリダイレクト
+    setTimeout(() => {
+      window.location.href = '/todos';
+    }, 1500);
+  };
+
+  const handleTwoFactorSubmit = async (e) => {
+    e.preventDefault();
+    setLoading(true);
+    setMessage('');
+
+    try {
+      const response = await client.request(VERIFY_TWO_FACTOR_MUTATION, {
+        loginToken,
+        code: twoFactorCode
+      });
+
+      if (response.verifyTwoFactor.success) {
+        completeLogin(response.verifyTwoFactor);
+      } else {
+        setMessage(response.verifyTwoFactor.message);
+        setIsSuccess(false);
+      }
+    } catch (error) {
+      console.error('Two-factor error:', error);
+      setMessage('ログイン中にエラーが発生しました');
+      setIsSuccess(false);
+    } finally {
+      setLoading(false);
+    }
+  };
+
   const styles = {
     container: {
       maxWidth: '400px',
@@ -183,6 +263,46 @@ const UserLogin = () => {
     );
   }
 
+  if (loginToken) {
+    return (
+      <div style={styles.container}>
+        <h1 style={styles.title}>2段階認証</h1>
+
+        <form onSubmit={handleTwoFactorSubmit}>
+          <div style={styles.formGroup}>
+            <label style={styles.label} htmlFor="twoFactorCode">確認コードまたはリカバリーコード:</label>
+            <input
+              style={styles.input}
+              type="text"
+              id="twoFactorCode"
+              name="twoFactorCode"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              value={twoFactorCode}
+              onChange={(e) => setTwoFactorCode(e.target.value)}
+              disabled={loading}
+              required
+            />
+          </div>
+
+          <button
+            type="submit"
+            style={styles.button}
+            disabled={loading}
+          >
+            {loading ? '確認中...' : '確認'}
+          </button>
+        </form>
+
+        {message && (
+          <div style={styles.message}>
+            {message}
+          </div>
+        )}
+      </div>
+    );
+  }
+
   return (
     <div style={styles.container}>
       <h1 style={styles.title}>ログイン</h1>
@@ -231,9 +351,26 @@ const UserLogin = () => {
         </div>
       )}
 
+      {oidcProviders.length > 0 && (
+        <div style={styles.buttonGroup}>
+          {oidcProviders.map(provider => (
+            <a
+              key={provider.Name}
+              href={`/auth/${encodeURIComponent(provider.Name)}/start`}
+              style={styles.secondaryButton}
+            >
+              {provider.DisplayName}でログイン
+            </a>
+          ))}
+        </div>
+      )}
+
       <p style={styles.linkText}>
         アカウントをお持ちでない方は <a href="/register" style={styles.link}>新規登録</a>
       </p>
+      <p style={styles.linkText}>
+        <a href="/forgot-password" style={styles.link}>パスワードをお忘れの方</a>
+      </p>
     </div>
   );
 };
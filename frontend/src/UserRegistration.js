@@ -58,13 +58,6 @@ const UserRegistration = () => {
       return;
     }
 
-    if (formData.password.length < 6) {
-      setMessage('パスワードは6文字以上で入力してください');
-      setIsSuccess(false);
-      setLoading(false);
-      return;
-    }
-
     try {
       const variables = {
         input: {
@@ -80,10 +73,6 @@ const UserRegistration = () => {
         setMessage(response.registerUser.message);
         setIsSuccess(true);
         setRegisteredUser(response.registerUser.user);
-        // 登録成功後、Todo一覧ページにリダイレクト
-        setTimeout(() => {
-          window.location.href = '/todos';
-        }, 1500);
       } else {
         setMessage(response.registerUser.message);
         setIsSuccess(false);
@@ -184,9 +173,14 @@ const UserRegistration = () => {
           登録日時: {new Date(registeredUser.createdAt).toLocaleString('ja-JP')}
         </div>
 
-        <p style={{textAlign: 'center', margin: '20px 0', color: '#28a745'}}>
-          Todo一覧ページに移動します...
+        <p style={{textAlign: 'center', margin: '20px 0'}}>
+          {registeredUser.email} 宛に確認メールを送信しました。<br />
+          メール内のリンクからメールアドレスの確認を完了してください。
         </p>
+
+        <div style={styles.buttonGroup}>
+          <a href="/login" style={styles.secondaryButton}>ログイン画面へ</a>
+        </div>
       </div>
     );
   }
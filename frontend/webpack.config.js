@@ -52,7 +52,11 @@ module.exports = {
     },
     port: 3000,
     proxy: {
-      '/query': 'http://localhost:8080'
+      // サブスクリプションの WebSocket も転送する
+      '/query': {
+        target: 'http://localhost:8080',
+        ws: true
+      }
     }
   }
 };
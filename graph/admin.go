@@ -0,0 +1,141 @@
+package graph
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"gorm.io/gorm"
+)
+
+// IDでユーザーを取得
+func (r *Resolver) findUser(id string) (*database.User, error) {
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("無効なユーザーIDです")
+	}
+
+	var dbUser database.User
+	if err := r.GORMDB.First(&dbUser, uint(userID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("ユーザーが見つかりません")
+		}
+		return nil, fmt.Errorf("ユーザー取得エラー: %v", err)
+	}
+	return &dbUser, nil
+}
+
+// 管理者が操作する対象のユーザーを取得する
+// 自分自身の無効化や削除で管理者がいなくならないよう、allowSelf が false の場合は自分自身を対象にできない
+func (r *Resolver) findManagedUser(ctx context.Context, id string, allowSelf bool) (*database.User, error) {
+	dbUser, err := r.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowSelf {
+		admin, err := r.currentUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if admin.ID == dbUser.ID {
+			return nil, fmt.Errorf("自分自身のアカウントには実行できません")
+		}
+	}
+	return dbUser, nil
+}
+
+// ユーザー一覧の検索条件をWHERE句に変換（メールアドレスまたは名前の部分一致）
+func applyUserSearch(db *gorm.DB, search *string) *gorm.DB {
+	if search == nil || strings.TrimSpace(*search) == "" {
+		return db
+	}
+	pattern := "%" + likeEscaper.Replace(strings.TrimSpace(*search)) + "%"
+	return db.Where("email LIKE ? OR name LIKE ?", pattern, pattern)
+}
+
+// ユーザー一覧のカーソル（IDの昇順で並べる）
+func encodeUserCursor(dbUser database.User) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(dbUser.ID), 10)))
+}
+
+func decodeUserCursor(cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("無効なカーソルです")
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("無効なカーソルです")
+	}
+	return uint(id), nil
+}
+
+// first/after に従ってキーセットページネーションでユーザーを取得
+// base は絞り込み条件のみを持つクエリで、並び順とLIMITはここで付与する
+func paginateUsers(base *gorm.DB, first *int32, after *string) (*model.UserConnection, error) {
+	limit := defaultPageSize
+	if first != nil {
+		limit = int(*first)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("firstには0以上の値を指定してください")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	var totalCount int64
+	if err := base.Session(&gorm.Session{}).Count(&totalCount).Error; err != nil {
+		return nil, fmt.Errorf("ユーザー取得エラー: %v", err)
+	}
+
+	query := base.Session(&gorm.Session{})
+	pageInfo := &model.PageInfo{}
+	if after != nil {
+		afterID, err := decodeUserCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id > ?", afterID)
+
+		var ids []uint
+		if err := base.Session(&gorm.Session{}).Where("id <= ?", afterID).Limit(1).Pluck("id", &ids).Error; err != nil {
+			return nil, fmt.Errorf("ユーザー取得エラー: %v", err)
+		}
+		pageInfo.HasPreviousPage = len(ids) > 0
+	}
+
+	// 次のページの有無を判定するため1件多く取得
+	var dbUsers []database.User
+	if err := query.Order("id ASC").Limit(limit + 1).Find(&dbUsers).Error; err != nil {
+		return nil, fmt.Errorf("ユーザー取得エラー: %v", err)
+	}
+	if len(dbUsers) > limit {
+		dbUsers = dbUsers[:limit]
+		pageInfo.HasNextPage = true
+	}
+
+	edges := make([]*model.UserEdge, 0, len(dbUsers))
+	for _, dbUser := range dbUsers {
+		edges = append(edges, &model.UserEdge{
+			Cursor: encodeUserCursor(dbUser),
+			Node:   toModelUser(dbUser),
+		})
+	}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
+		pageInfo.EndCursor = &edges[len(edges)-1].Cursor
+	}
+
+	return &model.UserConnection{
+		Edges:      edges,
+		PageInfo:   pageInfo,
+		TotalCount: int32(totalCount),
+	}, nil
+}
@@ -0,0 +1,182 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"github.com/vektah/gqlparser/v2/ast"
+	"gorm.io/gorm"
+)
+
+// APIトークンの接頭辞（漏洩時に検出しやすくするため）
+const apiTokenPrefix = "gqt_"
+
+// APIトークンの最終使用日時を更新する間隔
+const apiTokenLastUsedInterval = time.Minute
+
+const authInfoKey contextKey = "authInfo"
+
+const disabledUserMessage = "このアカウントは無効化されています。管理者にお問い合わせください"
+
+// リクエストを送ったユーザーの認証情報
+type authInfo struct {
+	UserID uint
+	User   *database.User
+	// APIトークンで認証した場合のみ設定する
+	Token *database.APIToken
+}
+
+// AuthMiddleware はHTTPリクエスト/レスポンスと認証情報をコンテキストに追加してから next を呼ぶ
+// トークンが無効な場合は 401 を返す
+func (r *Resolver) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := WithHTTPContext(req.Context(), req, w)
+		ctx, err := r.Authenticate(ctx, req)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// Authenticate はセッションCookieまたは Authorization: Bearer ヘッダー（APIトークンかJWT）から
+// リクエストを送ったユーザーを読み込み、コンテキストに追加する
+// どちらもない場合は未ログインとしてそのまま返し、トークンが無効な場合はエラーを返す
+func (r *Resolver) Authenticate(ctx context.Context, req *http.Request) (context.Context, error) {
+	if header := req.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return ctx, fmt.Errorf("Authorizationヘッダーの形式が正しくありません")
+		}
+
+		token = strings.TrimSpace(token)
+
+		// APIトークンは接頭辞で見分け、それ以外はJWTのアクセストークンとして検証する
+		var info authInfo
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			apiToken, err := r.findAPIToken(token)
+			if err != nil {
+				return ctx, err
+			}
+			info = authInfo{UserID: apiToken.UserID, Token: apiToken}
+		} else {
+			userID, err := r.parseAccessToken(token)
+			if err != nil {
+				return ctx, err
+			}
+			info = authInfo{UserID: userID}
+		}
+
+		dbUser, err := r.loadUser(info.UserID)
+		if err != nil {
+			return ctx, err
+		}
+		info.User = dbUser
+		return context.WithValue(ctx, authInfoKey, &info), nil
+	}
+
+	if r.SessionStore == nil {
+		return ctx, nil
+	}
+	session, err := r.SessionStore.Get(req, "session")
+	if err != nil {
+		return ctx, nil
+	}
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok {
+		return ctx, nil
+	}
+	// 削除・無効化されたユーザーのセッションは未ログインとして扱う
+	dbUser, err := r.loadUser(userID)
+	if err != nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, authInfoKey, &authInfo{UserID: userID, User: dbUser}), nil
+}
+
+// 認証したユーザーを読み込む（無効化されたユーザーはエラーにする）
+func (r *Resolver) loadUser(userID uint) (*database.User, error) {
+	var dbUser database.User
+	if err := r.GORMDB.First(&dbUser, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("ユーザーが存在しません")
+		}
+		return nil, fmt.Errorf("ユーザー取得エラー: %v", err)
+	}
+	if dbUser.DisabledAt != nil {
+		return nil, fmt.Errorf(disabledUserMessage)
+	}
+	return &dbUser, nil
+}
+
+// 有効なAPIトークンを取得し、最終使用日時を更新する
+func (r *Resolver) findAPIToken(token string) (*database.APIToken, error) {
+	var apiToken database.APIToken
+	err := r.GORMDB.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", hashToken(token), time.Now()).First(&apiToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("APIトークンが無効か有効期限が切れています")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("APIトークン取得エラー: %v", err)
+	}
+
+	// 書き込みを減らすため一定間隔でのみ更新
+	if apiToken.LastUsedAt == nil || time.Since(*apiToken.LastUsedAt) > apiTokenLastUsedInterval {
+		now := time.Now()
+		if err := r.GORMDB.Model(&apiToken).UpdateColumn("last_used_at", now).Error; err == nil {
+			apiToken.LastUsedAt = &now
+		}
+	}
+
+	return &apiToken, nil
+}
+
+// コンテキストから認証情報を取得
+func getAuthInfo(ctx context.Context) *authInfo {
+	info, _ := ctx.Value(authInfoKey).(*authInfo)
+	return info
+}
+
+// ログイン中のユーザーIDを取得
+// APIトークンで認証した場合は、操作の種類に必要なスコープを持っているかも確認する
+func (r *Resolver) authUserID(ctx context.Context) (uint, error) {
+	info := getAuthInfo(ctx)
+	if info == nil {
+		return 0, fmt.Errorf("認証が必要です")
+	}
+
+	if info.Token != nil {
+		required := model.APITokenScopeRead
+		if graphql.HasOperationContext(ctx) && graphql.GetOperationContext(ctx).Operation.Operation == ast.Mutation {
+			required = model.APITokenScopeWrite
+		}
+		if !slices.Contains(info.Token.ScopeList(), string(required)) {
+			return 0, fmt.Errorf("このAPIトークンには%sスコープがありません", required)
+		}
+	}
+
+	return info.UserID, nil
+}
+
+// APIトークンではなく、セッションまたはアクセストークン(JWT)でログインしていることを確認
+// トークンの発行など、漏洩したトークンで行われると困る操作に使う
+func (r *Resolver) requireSessionAuth(ctx context.Context) error {
+	info := getAuthInfo(ctx)
+	if info == nil {
+		return fmt.Errorf("認証が必要です")
+	}
+	if info.Token != nil {
+		return fmt.Errorf("この操作はAPIトークンでは実行できません")
+	}
+	return nil
+}
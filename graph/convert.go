@@ -0,0 +1,111 @@
+package graph
+
+import (
+	"strconv"
+
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"github.com/suimi34/golang-graphql/passwordpolicy"
+)
+
+// レスポンス用の日時フォーマット
+const timeLayout = "2006-01-02T15:04:05Z"
+
+// database.User をレスポンス用のモデルに変換
+func toModelUser(dbUser database.User) *model.User {
+	return &model.User{
+		ID:               strconv.Itoa(int(dbUser.ID)),
+		Name:             dbUser.Name,
+		Email:            dbUser.Email,
+		EmailVerified:    dbUser.VerifiedAt != nil,
+		TwoFactorEnabled: dbUser.TwoFactorEnabledAt != nil,
+		Role:             toModelRole(dbUser.Role),
+		Disabled:         dbUser.DisabledAt != nil,
+		CreatedAt:        dbUser.CreatedAt.Format(timeLayout),
+		UpdatedAt:        dbUser.UpdatedAt.Format(timeLayout),
+	}
+}
+
+// ログインや登録を行ったユーザー本人として database.User をレスポンス用のモデルに変換
+// （本人にのみ返すフィールドも返す）
+func toModelSelfUser(dbUser database.User) *model.User {
+	user := toModelUser(dbUser)
+	user.Self = true
+	return user
+}
+
+// データベースのロールをレスポンス用のロールに変換
+func toModelRole(role string) model.Role {
+	if role == database.RoleAdmin {
+		return model.RoleAdmin
+	}
+	return model.RoleUser
+}
+
+// レスポンス用のロールをデータベースのロールに変換
+func toDatabaseRole(role model.Role) string {
+	if role == model.RoleAdmin {
+		return database.RoleAdmin
+	}
+	return database.RoleUser
+}
+
+// database.Todo をレスポンス用のモデルに変換（Userはプリロード済みであること）
+func toModelTodo(dbTodo database.Todo) *model.Todo {
+	return &model.Todo{
+		ID:   strconv.Itoa(int(dbTodo.ID)),
+		Text: dbTodo.Text,
+		Done: dbTodo.Done,
+		User: toModelUser(dbTodo.User),
+	}
+}
+
+// database.Session をレスポンス用のモデルに変換
+func toModelActiveSession(dbSession database.Session, currentID string) *model.ActiveSession {
+	return &model.ActiveSession{
+		ID:         dbSession.PublicID(),
+		IPAddress:  dbSession.IPAddress,
+		UserAgent:  dbSession.UserAgent,
+		Current:    currentID != "" && dbSession.ID == currentID,
+		CreatedAt:  dbSession.CreatedAt.Format(timeLayout),
+		LastSeenAt: dbSession.LastSeenAt.Format(timeLayout),
+		ExpiresAt:  dbSession.ExpiresAt.Format(timeLayout),
+	}
+}
+
+// database.APIToken をレスポンス用のモデルに変換
+func toModelAPIToken(dbToken database.APIToken) *model.APIToken {
+	scopes := make([]model.APITokenScope, 0, len(dbToken.ScopeList()))
+	for _, scope := range dbToken.ScopeList() {
+		scopes = append(scopes, model.APITokenScope(scope))
+	}
+
+	apiToken := &model.APIToken{
+		ID:        strconv.Itoa(int(dbToken.ID)),
+		Name:      dbToken.Name,
+		Scopes:    scopes,
+		CreatedAt: dbToken.CreatedAt.Format(timeLayout),
+	}
+	if dbToken.LastUsedAt != nil {
+		lastUsedAt := dbToken.LastUsedAt.Format(timeLayout)
+		apiToken.LastUsedAt = &lastUsedAt
+	}
+	if dbToken.ExpiresAt != nil {
+		expiresAt := dbToken.ExpiresAt.Format(timeLayout)
+		apiToken.ExpiresAt = &expiresAt
+	}
+	return apiToken
+}
+
+// パスワードのルール違反を field の検証エラーに変換
+func toModelFieldErrors(field string, violations []passwordpolicy.Violation) []*model.FieldError {
+	fieldErrors := make([]*model.FieldError, 0, len(violations))
+	for _, violation := range violations {
+		fieldErrors = append(fieldErrors, &model.FieldError{
+			Field:   field,
+			Code:    model.FieldErrorCode(violation.Code),
+			Message: violation.Message,
+		})
+	}
+	return fieldErrors
+}
@@ -0,0 +1,38 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/suimi34/golang-graphql/graph/model"
+)
+
+// Directives は schema.graphqls で宣言したディレクティブの実装を返す
+// graph.Config の Directives に設定して使う
+func (r *Resolver) Directives() DirectiveRoot {
+	return DirectiveRoot{
+		Auth:    r.AuthDirective,
+		HasRole: r.HasRoleDirective,
+	}
+}
+
+// AuthDirective は @auth を付けたフィールドをログイン中のユーザーのみに制限する
+func (r *Resolver) AuthDirective(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+	if _, err := r.currentUser(ctx); err != nil {
+		return nil, err
+	}
+	return next(ctx)
+}
+
+// HasRoleDirective は @hasRole を付けたフィールドを指定したロールを持つユーザーのみに制限する
+func (r *Resolver) HasRoleDirective(ctx context.Context, obj any, next graphql.Resolver, role model.Role) (any, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !r.hasRole(dbUser, role) {
+		return nil, fmt.Errorf("この操作を行う権限がありません")
+	}
+	return next(ctx)
+}
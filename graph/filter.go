@@ -0,0 +1,75 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/suimi34/golang-graphql/graph/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 並び替え可能なフィールドと列名の対応（ここにない列では並び替えない）
+var todoOrderColumns = map[model.TodoOrderField]string{
+	model.TodoOrderFieldCreatedAt: "created_at",
+	model.TodoOrderFieldUpdatedAt: "updated_at",
+	model.TodoOrderFieldText:      "text",
+}
+
+// LIKE検索用にワイルドカード文字をエスケープ（MySQLの既定のエスケープ文字はバックスラッシュ）
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// TodoFilterをWHERE句に変換
+func applyTodoFilter(db *gorm.DB, filter *model.TodoFilter) (*gorm.DB, error) {
+	if filter == nil {
+		return db, nil
+	}
+
+	if filter.Done != nil {
+		db = db.Where("done = ?", *filter.Done)
+	}
+
+	if filter.CreatedAfter != nil {
+		createdAfter, err := time.Parse(time.RFC3339, *filter.CreatedAfter)
+		if err != nil {
+			return nil, fmt.Errorf("createdAfterはRFC3339形式で指定してください")
+		}
+		db = db.Where("created_at >= ?", createdAfter)
+	}
+
+	if filter.CreatedBefore != nil {
+		createdBefore, err := time.Parse(time.RFC3339, *filter.CreatedBefore)
+		if err != nil {
+			return nil, fmt.Errorf("createdBeforeはRFC3339形式で指定してください")
+		}
+		db = db.Where("created_at < ?", createdBefore)
+	}
+
+	if filter.TextContains != nil {
+		if text := strings.TrimSpace(*filter.TextContains); text != "" {
+			db = db.Where("text LIKE ?", "%"+likeEscaper.Replace(text)+"%")
+		}
+	}
+
+	return db, nil
+}
+
+// TodoOrderをORDER BY句に変換（未指定時は作成日時の新しい順）
+func applyTodoOrder(db *gorm.DB, order *model.TodoOrder) (*gorm.DB, error) {
+	if order == nil {
+		return db.Order("created_at DESC, id DESC"), nil
+	}
+
+	column, ok := todoOrderColumns[order.Field]
+	if !ok {
+		return nil, fmt.Errorf("並び替えできないフィールドです: %s", order.Field)
+	}
+	desc := order.Direction == model.OrderDirectionDesc
+
+	// 同じ値の行の順序を安定させるためIDを第2キーにする
+	return db.Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Name: column}, Desc: desc},
+		{Column: clause.Column{Name: "id"}, Desc: desc},
+	}}), nil
+}
@@ -0,0 +1,192 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// アクセストークン(JWT)の有効期限
+	accessTokenTTL = 15 * time.Minute
+	// リフレッシュトークンの有効期限
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// アクセストークンの発行者
+	accessTokenIssuer = "golang-graphql"
+)
+
+var (
+	errInvalidRefreshToken      = errors.New("リフレッシュトークンが無効か有効期限が切れています")
+	errRefreshTokenReused       = errors.New("リフレッシュトークンが再利用されたため、このログインを無効化しました。再度ログインしてください")
+	errRefreshTokenUserDisabled = errors.New(disabledUserMessage)
+)
+
+// ユーザーIDを subject に持つ署名済みのアクセストークンを発行する
+func (r *Resolver) issueAccessToken(userID uint) (string, error) {
+	if len(r.JWTSecret) == 0 {
+		return "", fmt.Errorf("JWTの署名鍵が設定されていません")
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    accessTokenIssuer,
+		Subject:   strconv.FormatUint(uint64(userID), 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(r.JWTSecret)
+	if err != nil {
+		return "", fmt.Errorf("アクセストークンの署名に失敗: %v", err)
+	}
+	return signed, nil
+}
+
+// アクセストークンを検証し、ユーザーIDを返す
+func (r *Resolver) parseAccessToken(token string) (uint, error) {
+	errInvalid := fmt.Errorf("アクセストークンが無効か有効期限が切れています")
+	if len(r.JWTSecret) == 0 {
+		return 0, errInvalid
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return r.JWTSecret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(accessTokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return 0, errInvalid
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, errInvalid
+	}
+	return uint(userID), nil
+}
+
+// アクセストークンと、familyID に属する新しいリフレッシュトークンを発行する
+// familyID が空の場合は新しいログインとして採番する
+func (r *Resolver) issueAuthTokens(tx *gorm.DB, userID uint, familyID string) (*model.AuthTokens, error) {
+	accessToken, err := r.issueAccessToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if familyID == "" {
+		familyID, _, err = generateToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshToken, refreshTokenHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Create(&database.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: refreshTokenHash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("リフレッシュトークンの保存に失敗: %v", err)
+	}
+
+	return &model.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int32(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// リフレッシュトークンを使用済みにして新しいトークンを発行する
+// 使用済みのトークンが再び使われた場合は漏洩とみなし、同じログインのトークンをすべて失効させる
+// ユーザーが削除・無効化されている場合も、同じログインのトークンをすべて失効させて発行しない
+func (r *Resolver) rotateRefreshToken(token string) (*model.AuthTokens, *database.User, error) {
+	var tokens *model.AuthTokens
+	var dbUser database.User
+	// 失効させた上で返すエラー（nil の場合は発行済み）
+	var revokedErr error
+
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		// 同じトークンが同時に使われないよう行ロックを取る
+		var refreshToken database.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hashToken(token)).First(&refreshToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		if refreshToken.RevokedAt != nil || !refreshToken.ExpiresAt.After(time.Now()) {
+			return errInvalidRefreshToken
+		}
+
+		now := time.Now()
+		if refreshToken.UsedAt != nil {
+			revokedErr = errRefreshTokenReused
+		} else {
+			// パスワードでのログインと同じく、削除・無効化されたユーザーには発行しない
+			err := tx.First(&dbUser, refreshToken.UserID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				revokedErr = errInvalidRefreshToken
+			} else if err != nil {
+				return err
+			} else if dbUser.DisabledAt != nil {
+				revokedErr = errRefreshTokenUserDisabled
+			}
+		}
+		if revokedErr != nil {
+			// 失効は確定させたいので、エラーではなく nil を返してコミットする
+			return tx.Model(&database.RefreshToken{}).
+				Where("family_id = ? AND revoked_at IS NULL", refreshToken.FamilyID).
+				Update("revoked_at", now).Error
+		}
+
+		if err := tx.Model(&refreshToken).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		tokens, err = r.issueAuthTokens(tx, refreshToken.UserID, refreshToken.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if revokedErr != nil {
+		return nil, nil, revokedErr
+	}
+	return tokens, &dbUser, nil
+}
+
+// リフレッシュトークンと同じログインのトークンをすべて失効させる
+func (r *Resolver) revokeRefreshTokenFamily(token string) (bool, error) {
+	var refreshToken database.RefreshToken
+	err := r.GORMDB.Where("token_hash = ?", hashToken(token)).First(&refreshToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("リフレッシュトークン取得エラー: %v", err)
+	}
+
+	err = r.GORMDB.Model(&database.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", refreshToken.FamilyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return false, fmt.Errorf("リフレッシュトークンの失効に失敗: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,136 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/mailer"
+	"gorm.io/gorm"
+)
+
+// ログイン失敗の監査記録に残す理由
+const (
+	loginFailureUnknownEmail  = "unknown_email"
+	loginFailureWrongPassword = "wrong_password"
+	loginFailureSecondFactor  = "invalid_second_factor"
+	loginFailureLocked        = "locked"
+)
+
+const loginLockedMessage = "ログイン試行回数が多すぎます。しばらくしてから再度お試しください"
+
+// リクエスト元のIPアドレスとUser-Agentを取得
+func (r *Resolver) requestClient(ctx context.Context) (string, string) {
+	httpReq := GetHTTPRequest(ctx)
+	if httpReq == nil {
+		return "", ""
+	}
+	return r.ClientIP.IP(httpReq), httpReq.UserAgent()
+}
+
+// アカウントまたはIPアドレスがロック中かどうかを返す
+// 記録を取得できない場合はログインを止めないよう false を返す
+func (r *Resolver) loginLocked(ctx context.Context, email string) bool {
+	if r.LoginGuard == nil {
+		return false
+	}
+
+	ip, _ := r.requestClient(ctx)
+	lockedUntil, err := r.LoginGuard.Check(ctx, email, ip, time.Now())
+	if err != nil {
+		log.Printf("%v", err)
+		return false
+	}
+	return !lockedUntil.IsZero()
+}
+
+// ログインの失敗を監査記録に残し、失敗回数を加算する
+// この失敗でアカウントがロックされた場合は、本人にロック解除用のメールを送信する
+func (r *Resolver) loginFailed(ctx context.Context, email string, dbUser *database.User, reason string) {
+	ip, userAgent := r.requestClient(ctx)
+
+	attempt := database.LoginAttempt{
+		Email:     truncateString(email, 255),
+		IPAddress: truncateString(ip, 45),
+		UserAgent: truncateString(userAgent, 512),
+		Reason:    reason,
+	}
+	if dbUser != nil {
+		attempt.UserID = &dbUser.ID
+	}
+	if err := r.GORMDB.Create(&attempt).Error; err != nil {
+		log.Printf("ログイン失敗の記録に失敗: %v", err)
+	}
+
+	// ロック中の試行は回数に含めない（ロック時間が延び続けないようにする）
+	if r.LoginGuard == nil || reason == loginFailureLocked {
+		return
+	}
+
+	account, err := r.LoginGuard.Fail(ctx, email, ip, time.Now())
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	if dbUser != nil && r.LoginGuard.JustLocked(account) {
+		if err := r.sendUnlockEmail(ctx, *dbUser); err != nil {
+			log.Printf("ロック解除メールの送信に失敗: %v", err)
+		}
+	}
+}
+
+// ログインに成功したアカウントの失敗回数を消去する
+func (r *Resolver) loginSucceeded(ctx context.Context, email string) {
+	if r.LoginGuard == nil {
+		return
+	}
+	if err := r.LoginGuard.Unlock(ctx, email); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// ロック解除用のトークンを発行し、ロック解除メールを送信する
+// 未使用の古いトークンは無効化する
+func (r *Resolver) sendUnlockEmail(ctx context.Context, dbUser database.User) error {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&database.AccountUnlockToken{}).Where("user_id = ? AND used_at IS NULL", dbUser.ID).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.AccountUnlockToken{
+			UserID:    dbUser.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(accountUnlockTokenTTL),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("ロック解除トークンの保存に失敗: %v", err)
+	}
+
+	unlockURL := r.BaseURL + "/unlock?token=" + url.QueryEscape(token)
+	return r.Mailer.Send(ctx, mailer.Message{
+		To:      dbUser.Email,
+		Subject: "アカウントを一時的にロックしました",
+		Body: dbUser.Name + " 様\n\n" +
+			"ログインの失敗が続いたため、アカウントを一時的にロックしました。\n" +
+			"ご本人による操作の場合は、以下のリンクからロックを解除できます（時間が経つと自動的に解除されます）。\n\n" +
+			unlockURL + "\n\n" +
+			"お心当たりがない場合は、パスワードの変更をおすすめします。\n",
+	})
+}
+
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
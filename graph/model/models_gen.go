@@ -2,15 +2,105 @@
 
 package model
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type ActiveSession struct {
+	ID         string `json:"id"`
+	IPAddress  string `json:"ipAddress"`
+	UserAgent  string `json:"userAgent"`
+	Current    bool   `json:"current"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+	ExpiresAt  string `json:"expiresAt"`
+}
+
+type AdminUserResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	User    *User  `json:"user,omitempty"`
+}
+
+type APIToken struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Scopes     []APITokenScope `json:"scopes"`
+	LastUsedAt *string         `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *string         `json:"expiresAt,omitempty"`
+	CreatedAt  string          `json:"createdAt"`
+}
+
+type AuthTokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int32  `json:"expiresIn"`
+}
+
+type ChangePasswordResponse struct {
+	Success     bool          `json:"success"`
+	Message     string        `json:"message"`
+	FieldErrors []*FieldError `json:"fieldErrors"`
+}
+
+type CreateAPITokenResponse struct {
+	Success  bool      `json:"success"`
+	Message  string    `json:"message"`
+	Token    *string   `json:"token,omitempty"`
+	APIToken *APIToken `json:"apiToken,omitempty"`
+}
+
+type DataExportResponse struct {
+	Success     bool    `json:"success"`
+	Message     string  `json:"message"`
+	DownloadURL *string `json:"downloadUrl,omitempty"`
+	ExpiresAt   *string `json:"expiresAt,omitempty"`
+}
+
+type DeleteAccountResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type EmailChangeResponse struct {
+	Success     bool          `json:"success"`
+	Message     string        `json:"message"`
+	FieldErrors []*FieldError `json:"fieldErrors"`
+}
+
+type EnableTwoFactorResponse struct {
+	Success         bool    `json:"success"`
+	Message         string  `json:"message"`
+	Secret          *string `json:"secret,omitempty"`
+	ProvisioningURI *string `json:"provisioningUri,omitempty"`
+}
+
+type FieldError struct {
+	Field   string         `json:"field"`
+	Code    FieldErrorCode `json:"code"`
+	Message string         `json:"message"`
+}
+
 type LoginUserInput struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
 type LoginUserResponse struct {
+	Success           bool    `json:"success"`
+	Message           string  `json:"message"`
+	User              *User   `json:"user,omitempty"`
+	TwoFactorRequired bool    `json:"twoFactorRequired"`
+	LoginToken        *string `json:"loginToken,omitempty"`
+}
+
+type LogoutUserResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
-	User    *User  `json:"user,omitempty"`
 }
 
 type Mutation struct {
@@ -20,6 +110,19 @@ type NewTodo struct {
 	Text string `json:"text"`
 }
 
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+}
+
+type PasswordResetResponse struct {
+	Success     bool          `json:"success"`
+	Message     string        `json:"message"`
+	FieldErrors []*FieldError `json:"fieldErrors"`
+}
+
 type Query struct {
 }
 
@@ -30,9 +133,13 @@ type RegisterUserInput struct {
 }
 
 type RegisterUserResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	User    *User  `json:"user,omitempty"`
+	Success     bool          `json:"success"`
+	Message     string        `json:"message"`
+	User        *User         `json:"user,omitempty"`
+	FieldErrors []*FieldError `json:"fieldErrors"`
+}
+
+type Subscription struct {
 }
 
 type Todo struct {
@@ -42,10 +149,429 @@ type Todo struct {
 	User *User  `json:"user"`
 }
 
-type User struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
+type TodoChangedEvent struct {
+	Type   TodoChangeType `json:"type"`
+	TodoID string         `json:"todoId"`
+	Todo   *Todo          `json:"todo,omitempty"`
+}
+
+type TodoConnection struct {
+	Edges    []*TodoEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+}
+
+type TodoEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Todo  `json:"node"`
+}
+
+type TodoFilter struct {
+	Done          *bool   `json:"done,omitempty"`
+	CreatedAfter  *string `json:"createdAfter,omitempty"`
+	CreatedBefore *string `json:"createdBefore,omitempty"`
+	TextContains  *string `json:"textContains,omitempty"`
+}
+
+type TodoOrder struct {
+	Field     TodoOrderField `json:"field"`
+	Direction OrderDirection `json:"direction"`
+}
+
+type TodoSearchResult struct {
+	Todo    *Todo   `json:"todo"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type TokenAuthResponse struct {
+	Success           bool        `json:"success"`
+	Message           string      `json:"message"`
+	Tokens            *AuthTokens `json:"tokens,omitempty"`
+	User              *User       `json:"user,omitempty"`
+	TwoFactorRequired bool        `json:"twoFactorRequired"`
+	LoginToken        *string     `json:"loginToken,omitempty"`
+}
+
+type TwoFactorResponse struct {
+	Success       bool     `json:"success"`
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+type UnlockAccountResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type UpdateProfileResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	User    *User  `json:"user,omitempty"`
+}
+
+type UpdateTodo struct {
+	Text *string `json:"text,omitempty"`
+	Done *bool   `json:"done,omitempty"`
+}
+
+type UserConnection struct {
+	Edges      []*UserEdge `json:"edges"`
+	PageInfo   *PageInfo   `json:"pageInfo"`
+	TotalCount int32       `json:"totalCount"`
+}
+
+type UserEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *User  `json:"node"`
+}
+
+type VerifyEmailResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type APITokenScope string
+
+const (
+	APITokenScopeRead  APITokenScope = "READ"
+	APITokenScopeWrite APITokenScope = "WRITE"
+)
+
+var AllAPITokenScope = []APITokenScope{
+	APITokenScopeRead,
+	APITokenScopeWrite,
+}
+
+func (e APITokenScope) IsValid() bool {
+	switch e {
+	case APITokenScopeRead, APITokenScopeWrite:
+		return true
+	}
+	return false
+}
+
+func (e APITokenScope) String() string {
+	return string(e)
+}
+
+func (e *APITokenScope) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = APITokenScope(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ApiTokenScope", str)
+	}
+	return nil
+}
+
+func (e APITokenScope) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *APITokenScope) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e APITokenScope) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type FieldErrorCode string
+
+const (
+	FieldErrorCodeRequired                FieldErrorCode = "REQUIRED"
+	FieldErrorCodeInvalidFormat           FieldErrorCode = "INVALID_FORMAT"
+	FieldErrorCodeTooShort                FieldErrorCode = "TOO_SHORT"
+	FieldErrorCodeTooLong                 FieldErrorCode = "TOO_LONG"
+	FieldErrorCodeMissingCharacterClasses FieldErrorCode = "MISSING_CHARACTER_CLASSES"
+	FieldErrorCodeContainsPersonalInfo    FieldErrorCode = "CONTAINS_PERSONAL_INFO"
+	FieldErrorCodeBreached                FieldErrorCode = "BREACHED"
+	FieldErrorCodeAlreadyRegistered       FieldErrorCode = "ALREADY_REGISTERED"
+)
+
+var AllFieldErrorCode = []FieldErrorCode{
+	FieldErrorCodeRequired,
+	FieldErrorCodeInvalidFormat,
+	FieldErrorCodeTooShort,
+	FieldErrorCodeTooLong,
+	FieldErrorCodeMissingCharacterClasses,
+	FieldErrorCodeContainsPersonalInfo,
+	FieldErrorCodeBreached,
+	FieldErrorCodeAlreadyRegistered,
+}
+
+func (e FieldErrorCode) IsValid() bool {
+	switch e {
+	case FieldErrorCodeRequired, FieldErrorCodeInvalidFormat, FieldErrorCodeTooShort, FieldErrorCodeTooLong, FieldErrorCodeMissingCharacterClasses, FieldErrorCodeContainsPersonalInfo, FieldErrorCodeBreached, FieldErrorCodeAlreadyRegistered:
+		return true
+	}
+	return false
+}
+
+func (e FieldErrorCode) String() string {
+	return string(e)
+}
+
+func (e *FieldErrorCode) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = FieldErrorCode(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid FieldErrorCode", str)
+	}
+	return nil
+}
+
+func (e FieldErrorCode) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *FieldErrorCode) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e FieldErrorCode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type OrderDirection string
+
+const (
+	OrderDirectionAsc  OrderDirection = "ASC"
+	OrderDirectionDesc OrderDirection = "DESC"
+)
+
+var AllOrderDirection = []OrderDirection{
+	OrderDirectionAsc,
+	OrderDirectionDesc,
+}
+
+func (e OrderDirection) IsValid() bool {
+	switch e {
+	case OrderDirectionAsc, OrderDirectionDesc:
+		return true
+	}
+	return false
+}
+
+func (e OrderDirection) String() string {
+	return string(e)
+}
+
+func (e *OrderDirection) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OrderDirection(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OrderDirection", str)
+	}
+	return nil
+}
+
+func (e OrderDirection) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *OrderDirection) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e OrderDirection) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type Role string
+
+const (
+	RoleUser  Role = "USER"
+	RoleAdmin Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Role) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Role) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type TodoChangeType string
+
+const (
+	TodoChangeTypeCreated TodoChangeType = "CREATED"
+	TodoChangeTypeUpdated TodoChangeType = "UPDATED"
+	TodoChangeTypeDeleted TodoChangeType = "DELETED"
+)
+
+var AllTodoChangeType = []TodoChangeType{
+	TodoChangeTypeCreated,
+	TodoChangeTypeUpdated,
+	TodoChangeTypeDeleted,
+}
+
+func (e TodoChangeType) IsValid() bool {
+	switch e {
+	case TodoChangeTypeCreated, TodoChangeTypeUpdated, TodoChangeTypeDeleted:
+		return true
+	}
+	return false
+}
+
+func (e TodoChangeType) String() string {
+	return string(e)
+}
+
+func (e *TodoChangeType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TodoChangeType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TodoChangeType", str)
+	}
+	return nil
+}
+
+func (e TodoChangeType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *TodoChangeType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e TodoChangeType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type TodoOrderField string
+
+const (
+	TodoOrderFieldCreatedAt TodoOrderField = "CREATED_AT"
+	TodoOrderFieldUpdatedAt TodoOrderField = "UPDATED_AT"
+	TodoOrderFieldText      TodoOrderField = "TEXT"
+)
+
+var AllTodoOrderField = []TodoOrderField{
+	TodoOrderFieldCreatedAt,
+	TodoOrderFieldUpdatedAt,
+	TodoOrderFieldText,
+}
+
+func (e TodoOrderField) IsValid() bool {
+	switch e {
+	case TodoOrderFieldCreatedAt, TodoOrderFieldUpdatedAt, TodoOrderFieldText:
+		return true
+	}
+	return false
+}
+
+func (e TodoOrderField) String() string {
+	return string(e)
+}
+
+func (e *TodoOrderField) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TodoOrderField(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TodoOrderField", str)
+	}
+	return nil
+}
+
+func (e TodoOrderField) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *TodoOrderField) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e TodoOrderField) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
 }
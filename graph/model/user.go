@@ -0,0 +1,19 @@
+package model
+
+// User はユーザーのレスポンス用モデル
+// Email には常にメールアドレスを設定しておき、本人と管理者にのみ返すかどうかは
+// gqlgen.yml でリゾルバーを指定した UserResolver.Email が判定する
+type User struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	EmailVerified    bool   `json:"emailVerified"`
+	TwoFactorEnabled bool   `json:"twoFactorEnabled"`
+	Role             Role   `json:"role"`
+	Disabled         bool   `json:"disabled"`
+	CreatedAt        string `json:"createdAt"`
+	UpdatedAt        string `json:"updatedAt"`
+	// リクエストを送ったユーザー本人であることが分かっている場合 true
+	// ログインや登録の直後など、コンテキストにまだ認証情報がない場合に使う
+	Self bool `json:"-"`
+}
@@ -0,0 +1,195 @@
+package graph
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// TODOの並び順 (created_at DESC, id DESC) 上の位置を表すカーソル
+type todoCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// カーソルを不透明な文字列にエンコード
+func encodeTodoCursor(dbTodo database.Todo) string {
+	raw := dbTodo.CreatedAt.Format(time.RFC3339Nano) + "|" + strconv.FormatUint(uint64(dbTodo.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// 文字列からカーソルをデコード
+func decodeTodoCursor(cursor string) (*todoCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("無効なカーソルです")
+	}
+
+	createdAtStr, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("無効なカーソルです")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return nil, fmt.Errorf("無効なカーソルです")
+	}
+
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("無効なカーソルです")
+	}
+
+	return &todoCursor{CreatedAt: createdAt, ID: uint(id)}, nil
+}
+
+// カーソルより後ろ（古い側）の行に絞り込む。inclusive ならカーソル自身も含める
+func olderThan(c *todoCursor, inclusive bool) func(*gorm.DB) *gorm.DB {
+	idOp := "<"
+	if inclusive {
+		idOp = "<="
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(created_at < ?) OR (created_at = ? AND id "+idOp+" ?)", c.CreatedAt, c.CreatedAt, c.ID)
+	}
+}
+
+// カーソルより前（新しい側）の行に絞り込む。inclusive ならカーソル自身も含める
+func newerThan(c *todoCursor, inclusive bool) func(*gorm.DB) *gorm.DB {
+	idOp := ">"
+	if inclusive {
+		idOp = ">="
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(created_at > ?) OR (created_at = ? AND id "+idOp+" ?)", c.CreatedAt, c.CreatedAt, c.ID)
+	}
+}
+
+// first/after/last/before に従ってキーセットページネーションでTODOを取得
+// base は絞り込み条件のみを持つクエリで、並び順とLIMITはここで付与する
+func paginateTodos(base *gorm.DB, first *int32, after *string, last *int32, before *string) (*model.TodoConnection, error) {
+	if first != nil && last != nil {
+		return nil, fmt.Errorf("firstとlastは同時に指定できません")
+	}
+
+	var afterCursor, beforeCursor *todoCursor
+	if after != nil {
+		c, err := decodeTodoCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		afterCursor = c
+	}
+	if before != nil {
+		c, err := decodeTodoCursor(*before)
+		if err != nil {
+			return nil, err
+		}
+		beforeCursor = c
+	}
+
+	backward := last != nil
+	limit := defaultPageSize
+	if first != nil {
+		limit = int(*first)
+	}
+	if last != nil {
+		limit = int(*last)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("firstとlastには0以上の値を指定してください")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	query := base.Session(&gorm.Session{}).Preload("User")
+	if afterCursor != nil {
+		query = query.Scopes(olderThan(afterCursor, false))
+	}
+	if beforeCursor != nil {
+		query = query.Scopes(newerThan(beforeCursor, false))
+	}
+
+	// 次のページの有無を判定するため1件多く取得
+	var dbTodos []database.Todo
+	if backward {
+		query = query.Order("created_at ASC, id ASC")
+	} else {
+		query = query.Order("created_at DESC, id DESC")
+	}
+	if err := query.Limit(limit + 1).Find(&dbTodos).Error; err != nil {
+		return nil, fmt.Errorf("TODO取得エラー: %v", err)
+	}
+
+	hasMore := len(dbTodos) > limit
+	if hasMore {
+		dbTodos = dbTodos[:limit]
+	}
+	if backward {
+		// 新しい順に戻す
+		for i, j := 0, len(dbTodos)-1; i < j; i, j = i+1, j-1 {
+			dbTodos[i], dbTodos[j] = dbTodos[j], dbTodos[i]
+		}
+	}
+
+	pageInfo := &model.PageInfo{}
+	if backward {
+		pageInfo.HasPreviousPage = hasMore
+		// カーソル自身を含め、before以降に行が残っていれば次ページあり
+		if beforeCursor != nil {
+			exists, err := existsTodo(base, olderThan(beforeCursor, true))
+			if err != nil {
+				return nil, err
+			}
+			pageInfo.HasNextPage = exists
+		}
+	} else {
+		pageInfo.HasNextPage = hasMore
+		// カーソル自身を含め、after以前に行が残っていれば前ページあり
+		if afterCursor != nil {
+			exists, err := existsTodo(base, newerThan(afterCursor, true))
+			if err != nil {
+				return nil, err
+			}
+			pageInfo.HasPreviousPage = exists
+		}
+	}
+
+	edges := make([]*model.TodoEdge, 0, len(dbTodos))
+	for _, dbTodo := range dbTodos {
+		edges = append(edges, &model.TodoEdge{
+			Cursor: encodeTodoCursor(dbTodo),
+			Node:   toModelTodo(dbTodo),
+		})
+	}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
+		pageInfo.EndCursor = &edges[len(edges)-1].Cursor
+	}
+
+	return &model.TodoConnection{
+		Edges:    edges,
+		PageInfo: pageInfo,
+	}, nil
+}
+
+// 条件に合うTODOが1件でも存在するか確認
+func existsTodo(base *gorm.DB, scope func(*gorm.DB) *gorm.DB) (bool, error) {
+	var ids []uint
+	if err := base.Session(&gorm.Session{}).Scopes(scope).Limit(1).Pluck("id", &ids).Error; err != nil {
+		return false, fmt.Errorf("TODO取得エラー: %v", err)
+	}
+	return len(ids) > 0, nil
+}
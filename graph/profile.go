@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/mailer"
+	"gorm.io/gorm"
+)
+
+// セッションに保存しているユーザーの情報をまとめて書き換えられるストア（database.SessionStore）
+type userSessionUpdater interface {
+	UpdateUserSessions(name string, userID uint, update func(values map[interface{}]interface{})) error
+}
+
+// 名前の入力ルールを検証し、違反していればメッセージを返す
+func validateName(name string) string {
+	if name == "" {
+		return "名前を入力してください"
+	}
+	if utf8.RuneCountInString(name) > 255 {
+		return "名前は255文字以内で入力してください"
+	}
+	return ""
+}
+
+// メールアドレス変更の確認トークンを発行し、新しいメールアドレスに確認メールを送信する
+// 未使用の古いトークンは無効化する
+func (r *Resolver) sendEmailChangeConfirmation(ctx context.Context, dbUser database.User, newEmail string) error {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&database.EmailChangeToken{}).Where("user_id = ? AND used_at IS NULL", dbUser.ID).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.EmailChangeToken{
+			UserID:    dbUser.ID,
+			NewEmail:  newEmail,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(emailChangeTokenTTL),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("確認トークンの保存に失敗: %v", err)
+	}
+
+	confirmURL := r.BaseURL + "/confirm-email?token=" + url.QueryEscape(token)
+	return r.Mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "メールアドレス変更の確認のお願い",
+		Body: dbUser.Name + " 様\n\n" +
+			"メールアドレスの変更を受け付けました。以下のリンクから24時間以内に変更を確定してください。\n\n" +
+			confirmURL + "\n\n" +
+			"お心当たりがない場合は、このメールを破棄してください。\n",
+	})
+}
+
+// 変更前のメールアドレスに、メールアドレスが変更されたことを通知する
+func (r *Resolver) notifyEmailChanged(ctx context.Context, dbUser database.User, oldEmail string) error {
+	return r.Mailer.Send(ctx, mailer.Message{
+		To:      oldEmail,
+		Subject: "メールアドレスが変更されました",
+		Body: dbUser.Name + " 様\n\n" +
+			"アカウントのメールアドレスが " + dbUser.Email + " に変更されました。\n" +
+			"今後のお知らせは新しいメールアドレスにお送りします。\n\n" +
+			"お心当たりがない場合は、至急パスワードを再設定してください。\n",
+	})
+}
+
+// セッションに保存しているメールアドレスを変更後のものにそろえる
+// データベースに保存しているセッションはすべて、それ以外のストアでは現在のリクエストのセッションのみ更新する
+func (r *Resolver) syncSessionEmail(ctx context.Context, userID uint, email string) error {
+	if r.SessionStore == nil {
+		return nil
+	}
+
+	if updater, ok := r.SessionStore.(userSessionUpdater); ok {
+		err := updater.UpdateUserSessions("session", userID, func(values map[interface{}]interface{}) {
+			values["email"] = email
+		})
+		if err != nil {
+			return fmt.Errorf("セッションの更新に失敗: %v", err)
+		}
+	}
+
+	httpReq := GetHTTPRequest(ctx)
+	httpRes := GetHTTPResponse(ctx)
+	if httpReq == nil || httpRes == nil {
+		return nil
+	}
+	session, err := r.SessionStore.Get(httpReq, "session")
+	if err != nil {
+		return nil
+	}
+	if sessionUserID, ok := session.Values["user_id"].(uint); !ok || sessionUserID != userID {
+		return nil
+	}
+	session.Values["email"] = email
+	return session.Save(httpReq, httpRes)
+}
@@ -2,9 +2,24 @@ package graph
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/suimi34/golang-graphql/clientip"
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"github.com/suimi34/golang-graphql/lockout"
+	"github.com/suimi34/golang-graphql/mailer"
+	"github.com/suimi34/golang-graphql/passwordhash"
+	"github.com/suimi34/golang-graphql/passwordpolicy"
+	"github.com/suimi34/golang-graphql/pubsub"
 	"gorm.io/gorm"
 )
 
@@ -14,7 +29,24 @@ import (
 
 type Resolver struct {
 	GORMDB       *gorm.DB
-	SessionStore *sessions.CookieStore
+	SessionStore sessions.Store
+	Mailer       mailer.Mailer
+	// メール本文のリンクに使うアプリケーションのURL（例: https://example.com）
+	BaseURL string
+	// true の場合、メールアドレスの確認が済んでいないユーザーはログインできない
+	RequireEmailVerification bool
+	// アクセストークン(JWT)の署名鍵
+	JWTSecret []byte
+	// ログイン試行の制限（nil の場合は制限しない）
+	LoginGuard *lockout.Guard
+	// パスワードのハッシュ化（nil の場合は passwordhash.NewDefault を使う）
+	PasswordHasher passwordhash.Hasher
+	// パスワードの入力ルール（nil の場合は passwordpolicy.Default を使う）
+	PasswordPolicy *passwordpolicy.Policy
+	// todoChanged の配信（nil の場合は配信せず、購読もできない）
+	Broker pubsub.Broker
+	// ログイン試行の制限や監査記録に使う、リクエスト元のIPアドレスの求め方
+	ClientIP clientip.Resolver
 }
 
 // コンテキストキー
@@ -43,3 +75,324 @@ func GetHTTPResponse(ctx context.Context) http.ResponseWriter {
 	w, _ := ctx.Value(httpResponseKey).(http.ResponseWriter)
 	return w
 }
+
+// ログイン中のユーザーを取得（ユーザーは Authenticate でリクエストごとに一度だけ読み込む）
+func (r *Resolver) currentUser(ctx context.Context) (*database.User, error) {
+	if _, err := r.authUserID(ctx); err != nil {
+		return nil, err
+	}
+	return getAuthInfo(ctx).User, nil
+}
+
+// ユーザーが指定したロールを持っているか（管理者は一般ユーザーのロールも持つ）
+func (r *Resolver) hasRole(dbUser *database.User, role model.Role) bool {
+	switch role {
+	case model.RoleUser:
+		return true
+	case model.RoleAdmin:
+		return dbUser.Role == database.RoleAdmin
+	}
+	return false
+}
+
+// ログイン中のユーザーが所有するTODOを取得
+func (r *Resolver) findOwnedTodo(ctx context.Context, id string) (*database.Todo, error) {
+	userID, err := r.authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	todoID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("無効なTODO IDです")
+	}
+
+	var dbTodo database.Todo
+	if err := r.GORMDB.Preload("User").First(&dbTodo, uint(todoID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("TODOが見つかりません")
+		}
+		return nil, fmt.Errorf("TODO取得エラー: %v", err)
+	}
+
+	if dbTodo.UserID != userID {
+		return nil, fmt.Errorf("このTODOを操作する権限がありません")
+	}
+
+	return &dbTodo, nil
+}
+
+// 現在のリクエストのセッションIDを取得（サーバー側にセッションを保存しないストアでは空文字）
+func (r *Resolver) currentSessionID(ctx context.Context) string {
+	httpReq := GetHTTPRequest(ctx)
+	if httpReq == nil || r.SessionStore == nil {
+		return ""
+	}
+
+	session, err := r.SessionStore.Get(httpReq, "session")
+	if err != nil {
+		return ""
+	}
+	return session.ID
+}
+
+// ユーザーの exceptID 以外のセッションをすべて削除し、リフレッシュトークンもすべて失効させる
+// 他の端末がリフレッシュトークンでアクセストークンを発行し続けられないよう、セッションと一緒に失効させる
+// 削除したセッションの件数を返す
+func (r *Resolver) signOutOtherDevices(userID uint, exceptID string) (int64, error) {
+	var revoked int64
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND id <> ?", userID, exceptID).Delete(&database.Session{})
+		if result.Error != nil {
+			return result.Error
+		}
+		revoked = result.RowsAffected
+
+		return tx.Model(&database.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("セッションの削除に失敗: %v", err)
+	}
+	return revoked, nil
+}
+
+// メールアドレスとパスワードでユーザーを認証する
+// 失敗はアカウントとIPアドレスごとに数え、続いた場合は一定時間ログインできなくする
+// ログインできない場合は nil と利用者向けのメッセージを返す
+func (r *Resolver) checkCredentials(ctx context.Context, email string, password string) (*database.User, string) {
+	// ロック中はパスワードが正しくても検証しない
+	if r.loginLocked(ctx, email) {
+		r.loginFailed(ctx, email, nil, loginFailureLocked)
+		return nil, loginLockedMessage
+	}
+
+	// メールアドレスでユーザーを検索
+	var dbUser database.User
+	if err := r.GORMDB.Where("email = ?", email).First(&dbUser).Error; err != nil {
+		r.loginFailed(ctx, email, nil, loginFailureUnknownEmail)
+		return nil, "メールアドレスまたはパスワードが正しくありません"
+	}
+
+	// パスワードを検証
+	if err := r.passwordHasher().Verify(dbUser.Password, password); err != nil {
+		r.loginFailed(ctx, email, &dbUser, loginFailureWrongPassword)
+		return nil, "メールアドレスまたはパスワードが正しくありません"
+	}
+
+	// 管理者に無効化されたユーザーはログインさせない
+	if dbUser.DisabledAt != nil {
+		return nil, disabledUserMessage
+	}
+
+	// 確認が必須の設定では、メールアドレスを確認するまでログインさせない
+	if r.RequireEmailVerification && dbUser.VerifiedAt == nil {
+		return nil, "メールアドレスの確認が完了していません。確認メールのリンクからメールアドレスを確認してください"
+	}
+
+	// 古いアルゴリズムやパラメータのハッシュは、平文のパスワードが手元にあるうちに作り直す
+	if r.passwordHasher().NeedsRehash(dbUser.Password) {
+		if err := r.rehashPassword(&dbUser, password); err != nil {
+			log.Printf("パスワードの再ハッシュ化に失敗: %v", err)
+		}
+	}
+
+	// 2段階認証が有効な場合は、確認コードの検証が済むまで失敗回数を残しておく
+	if dbUser.TwoFactorEnabledAt == nil {
+		r.loginSucceeded(ctx, email)
+	}
+
+	return &dbUser, ""
+}
+
+// BeginExternalLogin は外部のプロバイダー（OpenID Connect）で本人確認が済んだユーザーに、パスワードでのログインと同じ確認を行う
+// ログインできない場合は利用者向けのメッセージを返す
+// 2段階認証が有効な場合は、セッションを作らずに確認コードと一緒に送ってもらうトークンを返す
+func (r *Resolver) BeginExternalLogin(req *http.Request, dbUser database.User) (string, string) {
+	ctx := context.WithValue(req.Context(), httpRequestKey, req)
+
+	if r.loginLocked(ctx, dbUser.Email) {
+		r.loginFailed(ctx, dbUser.Email, &dbUser, loginFailureLocked)
+		return "", loginLockedMessage
+	}
+	if dbUser.DisabledAt != nil {
+		return "", disabledUserMessage
+	}
+
+	if dbUser.TwoFactorEnabledAt != nil {
+		loginToken, err := r.createTwoFactorChallenge(dbUser.ID)
+		if err != nil {
+			log.Printf("%v", err)
+			return "", "ログイン処理中にエラーが発生しました"
+		}
+		return loginToken, ""
+	}
+
+	r.loginSucceeded(ctx, dbUser.Email)
+	return "", ""
+}
+
+// パスワードのハッシュ化に使う Hasher を返す
+func (r *Resolver) passwordHasher() passwordhash.Hasher {
+	if r.PasswordHasher == nil {
+		return defaultPasswordHasher
+	}
+	return r.PasswordHasher
+}
+
+var defaultPasswordHasher = passwordhash.NewDefault()
+
+// 検証済みのパスワードを現在のパラメータでハッシュ化し直して保存する
+// 同時にパスワードが変更されていた場合に上書きしないよう、読み込んだ時のハッシュのままの場合だけ更新する
+func (r *Resolver) rehashPassword(dbUser *database.User, password string) error {
+	hashedPassword, err := r.passwordHasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	result := r.GORMDB.Model(&database.User{}).
+		Where("id = ? AND password = ?", dbUser.ID, dbUser.Password).
+		Update("password", hashedPassword)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		dbUser.Password = hashedPassword
+	}
+	return nil
+}
+
+// 現在のリクエストのセッションを破棄し、Cookieを削除する
+func (r *Resolver) endSession(ctx context.Context) error {
+	httpReq := GetHTTPRequest(ctx)
+	httpRes := GetHTTPResponse(ctx)
+	if httpReq == nil || httpRes == nil || r.SessionStore == nil {
+		return nil
+	}
+
+	session, _ := r.SessionStore.Get(httpReq, "session")
+	session.Values = map[interface{}]interface{}{}
+	session.Options.MaxAge = -1
+	return session.Save(httpReq, httpRes)
+}
+
+// ユーザーのセッションをすべて削除し、リフレッシュトークンをすべて失効させる
+// パスワードの再設定やアカウントの無効化など、すべての端末からログアウトさせる時に使う
+func signOutEverywhere(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.Session{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&database.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// ユーザーのログインセッションを新しいセッションIDで作成し、Cookieを発行する
+func (r *Resolver) startSession(ctx context.Context, dbUser database.User) error {
+	httpReq := GetHTTPRequest(ctx)
+	httpRes := GetHTTPResponse(ctx)
+	if httpReq == nil || httpRes == nil || r.SessionStore == nil {
+		return nil
+	}
+
+	return database.StartUserSession(r.SessionStore, httpReq, httpRes, dbUser)
+}
+
+// メールアドレスの形式を検証し、違反していればメッセージを返す
+func validateEmail(email string) string {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "有効なメールアドレスを入力してください"
+	}
+	return ""
+}
+
+// 登録済みのメールアドレスを指定された場合のメッセージと、field の検証エラー
+const emailTakenMessage = "このメールアドレスは既に登録されています"
+
+func emailTakenFieldErrors(field string) []*model.FieldError {
+	return []*model.FieldError{{
+		Field:   field,
+		Code:    model.FieldErrorCodeAlreadyRegistered,
+		Message: emailTakenMessage,
+	}}
+}
+
+// パスワードの入力ルールを検証し、違反を field の検証エラーとして返す
+// personalInfo にはパスワードに含めてはいけないユーザーのメールアドレスや名前を渡す
+func (r *Resolver) validatePassword(field string, password string, personalInfo ...string) []*model.FieldError {
+	policy := passwordpolicy.Default()
+	if r.PasswordPolicy != nil {
+		policy = *r.PasswordPolicy
+	}
+	return toModelFieldErrors(field, policy.Check(password, personalInfo...))
+}
+
+// メールアドレス確認用のトークンを発行し、確認メールを送信する
+// 未使用の古いトークンは無効化する
+func (r *Resolver) sendVerificationEmail(ctx context.Context, dbUser database.User) error {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&database.EmailVerificationToken{}).Where("user_id = ? AND used_at IS NULL", dbUser.ID).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.EmailVerificationToken{
+			UserID:    dbUser.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(emailVerificationTokenTTL),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("確認トークンの保存に失敗: %v", err)
+	}
+
+	verifyURL := r.BaseURL + "/verify?token=" + url.QueryEscape(token)
+	return r.Mailer.Send(ctx, mailer.Message{
+		To:      dbUser.Email,
+		Subject: "メールアドレスの確認のお願い",
+		Body: dbUser.Name + " 様\n\n" +
+			"ご登録ありがとうございます。以下のリンクから24時間以内にメールアドレスを確認してください。\n\n" +
+			verifyURL + "\n\n" +
+			"お心当たりがない場合は、このメールを破棄してください。\n",
+	})
+}
+
+// パスワード再設定用のトークンを発行し、再設定メールを送信する
+// 未使用の古いトークンは無効化する
+func (r *Resolver) sendPasswordResetEmail(ctx context.Context, dbUser database.User) error {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&database.PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", dbUser.ID).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.PasswordResetToken{
+			UserID:    dbUser.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(passwordResetTokenTTL),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("再設定トークンの保存に失敗: %v", err)
+	}
+
+	resetURL := r.BaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return r.Mailer.Send(ctx, mailer.Message{
+		To:      dbUser.Email,
+		Subject: "パスワード再設定のご案内",
+		Body: dbUser.Name + " 様\n\n" +
+			"以下のリンクから1時間以内にパスワードを再設定してください。\n\n" +
+			resetURL + "\n\n" +
+			"お心当たりがない場合は、このメールを破棄してください。\n",
+	})
+}
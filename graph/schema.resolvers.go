@@ -6,71 +6,106 @@ package graph
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pquerna/otp/totp"
 	"github.com/suimi34/golang-graphql/database"
 	"github.com/suimi34/golang-graphql/graph/model"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateTodo is the resolver for the createTodo field.
 func (r *mutationResolver) CreateTodo(ctx context.Context, input model.NewTodo) (*model.Todo, error) {
-	// セッションからユーザーIDを取得
-	httpReq := GetHTTPRequest(ctx)
-	if httpReq == nil {
-		return nil, fmt.Errorf("認証が必要です")
-	}
-
-	session, err := r.SessionStore.Get(httpReq, "session")
+	// セッションからユーザーを取得
+	dbUser, err := r.currentUser(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("認証が必要です")
-	}
-
-	userID, ok := session.Values["user_id"]
-	if !ok || userID == nil {
-		return nil, fmt.Errorf("認証が必要です")
-	}
-
-	userIDUint, ok := userID.(uint)
-	if !ok {
-		return nil, fmt.Errorf("無効なセッションです")
-	}
-
-	// ユーザー情報をGORMで取得
-	var dbUser database.User
-	if err := r.GORMDB.First(&dbUser, userIDUint).Error; err != nil {
-		return nil, fmt.Errorf("ユーザーが見つかりません: %v", err)
+		return nil, err
 	}
 
 	// TODOをGORMで作成
 	dbTodo := database.Todo{
 		Text:   input.Text,
 		Done:   false,
-		UserID: userIDUint,
+		UserID: dbUser.ID,
 	}
 
 	if err := r.GORMDB.Create(&dbTodo).Error; err != nil {
 		return nil, fmt.Errorf("TODOの作成に失敗: %v", err)
 	}
+	r.publishTodoChanged(ctx, model.TodoChangeTypeCreated, dbTodo)
 
 	// レスポンス用のモデルに変換
-	user := &model.User{
-		ID:        strconv.Itoa(int(dbUser.ID)),
-		Name:      dbUser.Name,
-		Email:     dbUser.Email,
-		CreatedAt: dbUser.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt: dbUser.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	dbTodo.User = *dbUser
+	return toModelTodo(dbTodo), nil
+}
+
+// UpdateTodo is the resolver for the updateTodo field.
+func (r *mutationResolver) UpdateTodo(ctx context.Context, id string, input model.UpdateTodo) (*model.Todo, error) {
+	dbTodo, err := r.findOwnedTodo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 指定されたフィールドのみ更新
+	updates := map[string]interface{}{}
+	if input.Text != nil {
+		text := strings.TrimSpace(*input.Text)
+		if text == "" {
+			return nil, fmt.Errorf("Todoを入力してください")
+		}
+		updates["text"] = text
+	}
+	if input.Done != nil {
+		updates["done"] = *input.Done
 	}
 
-	todo := &model.Todo{
-		ID:   strconv.Itoa(int(dbTodo.ID)),
-		Text: dbTodo.Text,
-		Done: dbTodo.Done,
-		User: user,
+	if len(updates) > 0 {
+		if err := r.GORMDB.Model(dbTodo).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("TODOの更新に失敗: %v", err)
+		}
+		r.publishTodoChanged(ctx, model.TodoChangeTypeUpdated, *dbTodo)
+	}
+
+	return toModelTodo(*dbTodo), nil
+}
+
+// ToggleTodo is the resolver for the toggleTodo field.
+func (r *mutationResolver) ToggleTodo(ctx context.Context, id string) (*model.Todo, error) {
+	dbTodo, err := r.findOwnedTodo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 完了状態を反転
+	if err := r.GORMDB.Model(dbTodo).Update("done", !dbTodo.Done).Error; err != nil {
+		return nil, fmt.Errorf("TODOの更新に失敗: %v", err)
 	}
-	return todo, nil
+	r.publishTodoChanged(ctx, model.TodoChangeTypeUpdated, *dbTodo)
+
+	return toModelTodo(*dbTodo), nil
+}
+
+// DeleteTodo is the resolver for the deleteTodo field.
+func (r *mutationResolver) DeleteTodo(ctx context.Context, id string) (string, error) {
+	dbTodo, err := r.findOwnedTodo(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.GORMDB.Delete(dbTodo).Error; err != nil {
+		return "", fmt.Errorf("TODOの削除に失敗: %v", err)
+	}
+	r.publishTodoChanged(ctx, model.TodoChangeTypeDeleted, *dbTodo)
+
+	return id, nil
 }
 
 // RegisterUser is the resolver for the registerUser field.
@@ -80,19 +115,43 @@ func (r *mutationResolver) RegisterUser(ctx context.Context, input model.Registe
 	email := strings.TrimSpace(input.Email)
 	password := input.Password
 
-	if name == "" || email == "" || password == "" {
+	var fieldErrors []*model.FieldError
+	for _, field := range []struct{ name, value, label string }{
+		{"name", name, "名前"},
+		{"email", email, "メールアドレス"},
+		{"password", password, "パスワード"},
+	} {
+		if field.value == "" {
+			fieldErrors = append(fieldErrors, &model.FieldError{
+				Field:   field.name,
+				Code:    model.FieldErrorCodeRequired,
+				Message: field.label + "を入力してください",
+			})
+		}
+	}
+	if len(fieldErrors) > 0 {
 		return &model.RegisterUserResponse{
-			Success: false,
-			Message: "すべてのフィールドを入力してください",
-			User:    nil,
+			Success:     false,
+			Message:     "すべてのフィールドを入力してください",
+			User:        nil,
+			FieldErrors: fieldErrors,
 		}, nil
 	}
 
-	if len(password) < 6 {
+	if message := validateEmail(email); message != "" {
+		fieldErrors = append(fieldErrors, &model.FieldError{
+			Field:   "email",
+			Code:    model.FieldErrorCodeInvalidFormat,
+			Message: message,
+		})
+	}
+	fieldErrors = append(fieldErrors, r.validatePassword("password", password, email, name)...)
+	if len(fieldErrors) > 0 {
 		return &model.RegisterUserResponse{
-			Success: false,
-			Message: "パスワードは6文字以上で入力してください",
-			User:    nil,
+			Success:     false,
+			Message:     fieldErrors[0].Message,
+			User:        nil,
+			FieldErrors: fieldErrors,
 		}, nil
 	}
 
@@ -100,14 +159,15 @@ func (r *mutationResolver) RegisterUser(ctx context.Context, input model.Registe
 	var existingUser database.User
 	if err := r.GORMDB.Where("email = ?", email).First(&existingUser).Error; err == nil {
 		return &model.RegisterUserResponse{
-			Success: false,
-			Message: "このメールアドレスは既に登録されています",
-			User:    nil,
+			Success:     false,
+			Message:     emailTakenMessage,
+			User:        nil,
+			FieldErrors: emailTakenFieldErrors("email"),
 		}, nil
 	}
 
 	// パスワードのハッシュ化
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := r.passwordHasher().Hash(password)
 	if err != nil {
 		return &model.RegisterUserResponse{
 			Success: false,
@@ -120,7 +180,7 @@ func (r *mutationResolver) RegisterUser(ctx context.Context, input model.Registe
 	user := database.User{
 		Name:     name,
 		Email:    email,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 	}
 
 	if err := r.GORMDB.Create(&user).Error; err != nil {
@@ -131,19 +191,17 @@ func (r *mutationResolver) RegisterUser(ctx context.Context, input model.Registe
 		}, nil
 	}
 
-	// レスポンス用のモデルに変換
-	responseUser := &model.User{
-		ID:        strconv.Itoa(int(user.ID)),
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt: user.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	// 確認メールの送信に失敗しても登録自体は完了しているため、再送信を案内する
+	message := "ユーザー登録が完了しました。確認メールのリンクからメールアドレスを確認してください"
+	if err := r.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("確認メールの送信に失敗: %v", err)
+		message = "ユーザー登録が完了しましたが、確認メールの送信に失敗しました。時間をおいて確認メールを再送信してください"
 	}
 
 	return &model.RegisterUserResponse{
 		Success: true,
-		Message: "ユーザー登録が完了しました",
-		User:    responseUser,
+		Message: message,
+		User:    toModelSelfUser(user),
 	}, nil
 }
 
@@ -161,93 +219,1438 @@ func (r *mutationResolver) LoginUser(ctx context.Context, input model.LoginUserI
 		}, nil
 	}
 
-	// メールアドレスでユーザーを検索
-	var dbUser database.User
-	if err := r.GORMDB.Where("email = ?", email).First(&dbUser).Error; err != nil {
+	dbUser, message := r.checkCredentials(ctx, email, password)
+	if dbUser == nil {
 		return &model.LoginUserResponse{
 			Success: false,
-			Message: "メールアドレスまたはパスワードが正しくありません",
+			Message: message,
 			User:    nil,
 		}, nil
 	}
 
-	// パスワードを検証
-	if err := bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(password)); err != nil {
+	// 2段階認証が有効な場合は、確認コードを受け取るまでセッションを作らない
+	if dbUser.TwoFactorEnabledAt != nil {
+		loginToken, err := r.createTwoFactorChallenge(dbUser.ID)
+		if err != nil {
+			return &model.LoginUserResponse{
+				Success: false,
+				Message: "ログイン処理中にエラーが発生しました",
+				User:    nil,
+			}, nil
+		}
+		return &model.LoginUserResponse{
+			Success:           false,
+			Message:           twoFactorRequiredMessage,
+			User:              nil,
+			TwoFactorRequired: true,
+			LoginToken:        &loginToken,
+		}, nil
+	}
+
+	// セッションを作成
+	if err := r.startSession(ctx, *dbUser); err != nil {
 		return &model.LoginUserResponse{
 			Success: false,
-			Message: "メールアドレスまたはパスワードが正しくありません",
+			Message: "セッションの作成に失敗しました",
 			User:    nil,
 		}, nil
 	}
 
-	// セッションを作成
-	httpReq := GetHTTPRequest(ctx)
-	httpRes := GetHTTPResponse(ctx)
-	if httpReq != nil && httpRes != nil && r.SessionStore != nil {
-		session, _ := r.SessionStore.Get(httpReq, "session")
-		session.Values["user_id"] = dbUser.ID
-		session.Values["email"] = dbUser.Email
-		if err := session.Save(httpReq, httpRes); err != nil {
-			return &model.LoginUserResponse{
+	return &model.LoginUserResponse{
+		Success: true,
+		Message: "ログインに成功しました",
+		User:    toModelSelfUser(*dbUser),
+	}, nil
+}
+
+// LogoutUser is the resolver for the logoutUser field.
+func (r *mutationResolver) LogoutUser(ctx context.Context) (*model.LogoutUserResponse, error) {
+	// セッションを破棄
+	if err := r.endSession(ctx); err != nil {
+		return &model.LogoutUserResponse{
+			Success: false,
+			Message: "セッションの破棄に失敗しました",
+		}, nil
+	}
+
+	return &model.LogoutUserResponse{
+		Success: true,
+		Message: "ログアウトしました",
+	}, nil
+}
+
+// LoginWithToken is the resolver for the loginWithToken field.
+func (r *mutationResolver) LoginWithToken(ctx context.Context, input model.LoginUserInput) (*model.TokenAuthResponse, error) {
+	email := strings.TrimSpace(input.Email)
+	password := input.Password
+
+	if email == "" || password == "" {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: "メールアドレスとパスワードを入力してください",
+		}, nil
+	}
+
+	dbUser, message := r.checkCredentials(ctx, email, password)
+	if dbUser == nil {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: message,
+		}, nil
+	}
+
+	if dbUser.TwoFactorEnabledAt != nil {
+		loginToken, err := r.createTwoFactorChallenge(dbUser.ID)
+		if err != nil {
+			return &model.TokenAuthResponse{
 				Success: false,
-				Message: "セッションの作成に失敗しました",
-				User:    nil,
+				Message: "ログイン処理中にエラーが発生しました",
 			}, nil
 		}
+		return &model.TokenAuthResponse{
+			Success:           false,
+			Message:           twoFactorRequiredMessage,
+			TwoFactorRequired: true,
+			LoginToken:        &loginToken,
+		}, nil
 	}
 
-	// レスポンス用のモデルに変換
-	responseUser := &model.User{
-		ID:        strconv.Itoa(int(dbUser.ID)),
-		Name:      dbUser.Name,
-		Email:     dbUser.Email,
-		CreatedAt: dbUser.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt: dbUser.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	// セッションは作らず、モバイルアプリなどが保持するトークンを発行する
+	tokens, err := r.issueAuthTokens(r.GORMDB, dbUser.ID, "")
+	if err != nil {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: "トークンの発行に失敗しました",
+		}, nil
+	}
+
+	return &model.TokenAuthResponse{
+		Success: true,
+		Message: "ログインに成功しました",
+		Tokens:  tokens,
+		User:    toModelSelfUser(*dbUser),
+	}, nil
+}
+
+// RefreshToken is the resolver for the refreshToken field.
+func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*model.TokenAuthResponse, error) {
+	if refreshToken == "" {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: errInvalidRefreshToken.Error(),
+		}, nil
+	}
+
+	tokens, dbUser, err := r.rotateRefreshToken(refreshToken)
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) || errors.Is(err, errRefreshTokenUserDisabled) {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: "トークンの更新中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.TokenAuthResponse{
+		Success: true,
+		Message: "トークンを更新しました",
+		Tokens:  tokens,
+		User:    toModelSelfUser(*dbUser),
+	}, nil
+}
+
+// RevokeRefreshToken is the resolver for the revokeRefreshToken field.
+func (r *mutationResolver) RevokeRefreshToken(ctx context.Context, refreshToken string) (bool, error) {
+	// ログアウト時にアプリから呼ばれる。トークンを知っていれば失効できる
+	return r.revokeRefreshTokenFamily(refreshToken)
+}
+
+// VerifyTwoFactor is the resolver for the verifyTwoFactor field.
+func (r *mutationResolver) VerifyTwoFactor(ctx context.Context, loginToken string, code string) (*model.LoginUserResponse, error) {
+	dbUser, message := r.completeTwoFactorChallenge(ctx, loginToken, code)
+	if dbUser == nil {
+		return &model.LoginUserResponse{
+			Success: false,
+			Message: message,
+			User:    nil,
+		}, nil
+	}
+
+	if err := r.startSession(ctx, *dbUser); err != nil {
+		return &model.LoginUserResponse{
+			Success: false,
+			Message: "セッションの作成に失敗しました",
+			User:    nil,
+		}, nil
 	}
 
 	return &model.LoginUserResponse{
 		Success: true,
 		Message: "ログインに成功しました",
-		User:    responseUser,
+		User:    toModelSelfUser(*dbUser),
 	}, nil
 }
 
-// Todos is the resolver for the todos field.
-func (r *queryResolver) Todos(ctx context.Context) ([]*model.Todo, error) {
-	// GORMでTODO一覧を取得（Userも含む）
-	var dbTodos []database.Todo
-	if err := r.GORMDB.Preload("User").Order("created_at DESC").Find(&dbTodos).Error; err != nil {
-		return nil, fmt.Errorf("TODO取得エラー: %v", err)
+// VerifyTwoFactorWithToken is the resolver for the verifyTwoFactorWithToken field.
+func (r *mutationResolver) VerifyTwoFactorWithToken(ctx context.Context, loginToken string, code string) (*model.TokenAuthResponse, error) {
+	dbUser, message := r.completeTwoFactorChallenge(ctx, loginToken, code)
+	if dbUser == nil {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: message,
+		}, nil
 	}
 
-	// レスポンス用のモデルに変換
-	var todos []*model.Todo
-	for _, dbTodo := range dbTodos {
-		user := &model.User{
-			ID:        strconv.Itoa(int(dbTodo.User.ID)),
-			Name:      dbTodo.User.Name,
-			Email:     dbTodo.User.Email,
-			CreatedAt: dbTodo.User.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			UpdatedAt: dbTodo.User.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	tokens, err := r.issueAuthTokens(r.GORMDB, dbUser.ID, "")
+	if err != nil {
+		return &model.TokenAuthResponse{
+			Success: false,
+			Message: "トークンの発行に失敗しました",
+		}, nil
+	}
+
+	return &model.TokenAuthResponse{
+		Success: true,
+		Message: "ログインに成功しました",
+		Tokens:  tokens,
+		User:    toModelSelfUser(*dbUser),
+	}, nil
+}
+
+// EnableTwoFactor is the resolver for the enableTwoFactor field.
+func (r *mutationResolver) EnableTwoFactor(ctx context.Context) (*model.EnableTwoFactorResponse, error) {
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbUser.TwoFactorEnabledAt != nil {
+		return &model.EnableTwoFactorResponse{
+			Success: false,
+			Message: "2段階認証は既に有効です",
+		}, nil
+	}
+
+	// confirmTwoFactor で確認コードを検証するまでは有効にしない
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: dbUser.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("シークレットの生成に失敗: %v", err)
+	}
+
+	secret := key.Secret()
+	if err := r.GORMDB.Model(dbUser).Updates(map[string]interface{}{
+		"two_factor_secret":    secret,
+		"two_factor_last_step": nil,
+	}).Error; err != nil {
+		return &model.EnableTwoFactorResponse{
+			Success: false,
+			Message: "2段階認証の設定中にエラーが発生しました",
+		}, nil
+	}
+
+	provisioningURI := key.URL()
+	return &model.EnableTwoFactorResponse{
+		Success:         true,
+		Message:         "認証アプリに登録し、表示された確認コードを入力してください",
+		Secret:          &secret,
+		ProvisioningURI: &provisioningURI,
+	}, nil
+}
+
+// ConfirmTwoFactor is the resolver for the confirmTwoFactor field.
+func (r *mutationResolver) ConfirmTwoFactor(ctx context.Context, code string) (*model.TwoFactorResponse, error) {
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbUser.TwoFactorEnabledAt != nil {
+		return &model.TwoFactorResponse{
+			Success: false,
+			Message: "2段階認証は既に有効です",
+		}, nil
+	}
+	if dbUser.TwoFactorSecret == nil {
+		return &model.TwoFactorResponse{
+			Success: false,
+			Message: "先に2段階認証の設定を開始してください",
+		}, nil
+	}
+	var recoveryCodes []string
+	errInvalidCode := errors.New("invalid code")
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		ok, err := acceptTOTP(tx, *dbUser, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidCode
+		}
+		if err := tx.Model(dbUser).Update("two_factor_enabled_at", time.Now()).Error; err != nil {
+			return err
 		}
+		recoveryCodes, err = replaceRecoveryCodes(tx, dbUser.ID)
+		return err
+	})
+	if errors.Is(err, errInvalidCode) {
+		return &model.TwoFactorResponse{
+			Success: false,
+			Message: "確認コードが正しくありません",
+		}, nil
+	}
+	if err != nil {
+		return &model.TwoFactorResponse{
+			Success: false,
+			Message: "2段階認証の設定中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.TwoFactorResponse{
+		Success:       true,
+		Message:       "2段階認証を有効にしました。リカバリーコードを安全な場所に保管してください",
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// DisableTwoFactor is the resolver for the disableTwoFactor field.
+func (r *mutationResolver) DisableTwoFactor(ctx context.Context, currentPassword string) (*model.TwoFactorResponse, error) {
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 現在のパスワードで再認証
+	if err := r.passwordHasher().Verify(dbUser.Password, currentPassword); err != nil {
+		return &model.TwoFactorResponse{
+			Success: false,
+			Message: "現在のパスワードが正しくありません",
+		}, nil
+	}
 
-		todo := &model.Todo{
-			ID:   strconv.Itoa(int(dbTodo.ID)),
-			Text: dbTodo.Text,
-			Done: dbTodo.Done,
-			User: user,
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(dbUser).Updates(map[string]interface{}{
+			"two_factor_secret":     nil,
+			"two_factor_enabled_at": nil,
+			"two_factor_last_step":  nil,
+		}).Error; err != nil {
+			return err
 		}
-		todos = append(todos, todo)
+		if err := tx.Where("user_id = ?", dbUser.ID).Delete(&database.TwoFactorRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", dbUser.ID).Delete(&database.TwoFactorChallenge{}).Error
+	})
+	if err != nil {
+		return &model.TwoFactorResponse{
+			Success: false,
+			Message: "2段階認証の解除中にエラーが発生しました",
+		}, nil
 	}
 
-	return todos, nil
+	return &model.TwoFactorResponse{
+		Success: true,
+		Message: "2段階認証を無効にしました",
+	}, nil
 }
 
-// Mutation returns MutationResolver implementation.
-func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+// ChangePassword is the resolver for the changePassword field.
+func (r *mutationResolver) ChangePassword(ctx context.Context, currentPassword string, newPassword string) (*model.ChangePasswordResponse, error) {
+	// 漏洩したAPIトークンでパスワードを変えられ、他の端末をログアウトさせられないよう、セッションまたはJWTでのログインを必須にする
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-// Query returns QueryResolver implementation.
-func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+	if currentPassword == "" || newPassword == "" {
+		return &model.ChangePasswordResponse{
+			Success: false,
+			Message: "現在のパスワードと新しいパスワードを入力してください",
+		}, nil
+	}
+
+	// 現在のパスワードで再認証
+	if err := r.passwordHasher().Verify(dbUser.Password, currentPassword); err != nil {
+		return &model.ChangePasswordResponse{
+			Success: false,
+			Message: "現在のパスワードが正しくありません",
+		}, nil
+	}
+
+	if fieldErrors := r.validatePassword("newPassword", newPassword, dbUser.Email, dbUser.Name); len(fieldErrors) > 0 {
+		return &model.ChangePasswordResponse{
+			Success:     false,
+			Message:     fieldErrors[0].Message,
+			FieldErrors: fieldErrors,
+		}, nil
+	}
+
+	// パスワードのハッシュ化
+	hashedPassword, err := r.passwordHasher().Hash(newPassword)
+	if err != nil {
+		return &model.ChangePasswordResponse{
+			Success: false,
+			Message: "パスワードの処理中にエラーが発生しました",
+		}, nil
+	}
+
+	if err := r.GORMDB.Model(dbUser).Update("password", hashedPassword).Error; err != nil {
+		return &model.ChangePasswordResponse{
+			Success: false,
+			Message: "パスワードの変更中にエラーが発生しました",
+		}, nil
+	}
+
+	// 現在のセッション以外とリフレッシュトークンを無効化
+	if _, err := r.signOutOtherDevices(dbUser.ID, r.currentSessionID(ctx)); err != nil {
+		return nil, err
+	}
+
+	return &model.ChangePasswordResponse{
+		Success: true,
+		Message: "パスワードを変更しました",
+	}, nil
+}
+
+// UpdateProfile is the resolver for the updateProfile field.
+func (r *mutationResolver) UpdateProfile(ctx context.Context, name string) (*model.UpdateProfileResponse, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if message := validateName(name); message != "" {
+		return &model.UpdateProfileResponse{
+			Success: false,
+			Message: message,
+		}, nil
+	}
+
+	if err := r.GORMDB.Model(dbUser).Update("name", name).Error; err != nil {
+		return &model.UpdateProfileResponse{
+			Success: false,
+			Message: "プロフィールの更新中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.UpdateProfileResponse{
+		Success: true,
+		Message: "プロフィールを更新しました",
+		User:    toModelSelfUser(*dbUser),
+	}, nil
+}
+
+// RequestEmailChange is the resolver for the requestEmailChange field.
+func (r *mutationResolver) RequestEmailChange(ctx context.Context, newEmail string) (*model.EmailChangeResponse, error) {
+	// 漏洩したAPIトークンでアカウントを乗っ取られないよう、セッションまたはJWTでのログインを必須にする
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	newEmail = strings.TrimSpace(newEmail)
+	if message := validateEmail(newEmail); message != "" {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: message,
+			FieldErrors: []*model.FieldError{{
+				Field:   "newEmail",
+				Code:    model.FieldErrorCodeInvalidFormat,
+				Message: message,
+			}},
+		}, nil
+	}
+	if strings.EqualFold(newEmail, dbUser.Email) {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: "現在と同じメールアドレスです",
+		}, nil
+	}
+
+	// 確認メールを送る前に、ユニーク制約に違反しないか確認する
+	var count int64
+	if err := r.GORMDB.Model(&database.User{}).Where("email = ?", newEmail).Count(&count).Error; err != nil {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: "メールアドレス変更の受付中にエラーが発生しました",
+		}, nil
+	}
+	if count > 0 {
+		return &model.EmailChangeResponse{
+			Success:     false,
+			Message:     emailTakenMessage,
+			FieldErrors: emailTakenFieldErrors("newEmail"),
+		}, nil
+	}
+
+	if err := r.sendEmailChangeConfirmation(ctx, *dbUser, newEmail); err != nil {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: "メールの送信に失敗しました",
+		}, nil
+	}
+
+	return &model.EmailChangeResponse{
+		Success: true,
+		Message: "新しいメールアドレスに確認メールを送信しました。メールのリンクから変更を確定してください",
+	}, nil
+}
+
+// ConfirmEmailChange is the resolver for the confirmEmailChange field.
+func (r *mutationResolver) ConfirmEmailChange(ctx context.Context, token string) (*model.EmailChangeResponse, error) {
+	if token == "" {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています",
+		}, nil
+	}
+
+	errInvalidToken := errors.New("invalid token")
+	errEmailTaken := errors.New("email taken")
+	var dbUser database.User
+	var oldEmail string
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		// 同じトークンが同時に使われないよう行ロックを取る
+		var changeToken database.EmailChangeToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+			First(&changeToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		// 申請後に他のユーザーが同じメールアドレスで登録していないか確認する
+		var count int64
+		if err := tx.Model(&database.User{}).Where("email = ? AND id <> ?", changeToken.NewEmail, changeToken.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errEmailTaken
+		}
+
+		if err := tx.First(&dbUser, changeToken.UserID).Error; err != nil {
+			return err
+		}
+		oldEmail = dbUser.Email
+
+		// リンクを開けたので新しいメールアドレスは確認済みとして扱う
+		now := time.Now()
+		if err := tx.Model(&dbUser).Updates(map[string]interface{}{
+			"email":       changeToken.NewEmail,
+			"verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&changeToken).Update("used_at", now).Error
+	})
+	if errors.Is(err, errInvalidToken) {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています。もう一度変更を申請してください",
+		}, nil
+	}
+	if errors.Is(err, errEmailTaken) {
+		return &model.EmailChangeResponse{
+			Success:     false,
+			Message:     emailTakenMessage,
+			FieldErrors: emailTakenFieldErrors("newEmail"),
+		}, nil
+	}
+	if err != nil {
+		return &model.EmailChangeResponse{
+			Success: false,
+			Message: "メールアドレスの変更中にエラーが発生しました",
+		}, nil
+	}
+
+	// 変更自体は完了しているため、通知やセッションの更新に失敗してもログに残すだけにする
+	if err := r.notifyEmailChanged(ctx, dbUser, oldEmail); err != nil {
+		log.Printf("メールアドレス変更の通知に失敗: %v", err)
+	}
+	if err := r.syncSessionEmail(ctx, dbUser.ID, dbUser.Email); err != nil {
+		log.Printf("%v", err)
+	}
+
+	return &model.EmailChangeResponse{
+		Success: true,
+		Message: "メールアドレスを変更しました",
+	}, nil
+}
+
+// RequestPasswordReset is the resolver for the requestPasswordReset field.
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, email string) (*model.PasswordResetResponse, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return &model.PasswordResetResponse{
+			Success: false,
+			Message: "メールアドレスを入力してください",
+		}, nil
+	}
+
+	// 登録の有無が分からないよう、ユーザーが存在しない場合も同じメッセージを返す
+	sentResponse := &model.PasswordResetResponse{
+		Success: true,
+		Message: "登録されているメールアドレスの場合、パスワード再設定用のメールを送信しました",
+	}
+
+	var dbUser database.User
+	if err := r.GORMDB.Where("email = ?", email).First(&dbUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return sentResponse, nil
+		}
+		return &model.PasswordResetResponse{
+			Success: false,
+			Message: "パスワード再設定の受付中にエラーが発生しました",
+		}, nil
+	}
+
+	// 送信の失敗を返すと登録済みのメールアドレスだと分かってしまうため、ログに残すだけにする
+	if err := r.sendPasswordResetEmail(ctx, dbUser); err != nil {
+		log.Printf("パスワード再設定メールの送信に失敗: %v", err)
+	}
+
+	return sentResponse, nil
+}
+
+// ResetPassword is the resolver for the resetPassword field.
+func (r *mutationResolver) ResetPassword(ctx context.Context, token string, newPassword string) (*model.PasswordResetResponse, error) {
+	if token == "" || newPassword == "" {
+		return &model.PasswordResetResponse{
+			Success: false,
+			Message: "新しいパスワードを入力してください",
+		}, nil
+	}
+
+	// メールアドレスや名前を含んでいないかはトークンのユーザーを読み込んでから検証する
+	if fieldErrors := r.validatePassword("newPassword", newPassword); len(fieldErrors) > 0 {
+		return &model.PasswordResetResponse{
+			Success:     false,
+			Message:     fieldErrors[0].Message,
+			FieldErrors: fieldErrors,
+		}, nil
+	}
+
+	errInvalidToken := errors.New("invalid token")
+	errHashFailed := errors.New("hash failed")
+	var fieldErrors []*model.FieldError
+	var dbUser database.User
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		// 同じトークンが同時に使われないよう行ロックを取る
+		var resetToken database.PasswordResetToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+			First(&resetToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.First(&dbUser, resetToken.UserID).Error; err != nil {
+			return err
+		}
+		// ルールに違反している場合はトークンを使わずに残し、入力し直してもらう
+		if fieldErrors = r.validatePassword("newPassword", newPassword, dbUser.Email, dbUser.Name); len(fieldErrors) > 0 {
+			return nil
+		}
+
+		// パスワードのハッシュ化
+		hashedPassword, err := r.passwordHasher().Hash(newPassword)
+		if err != nil {
+			return errHashFailed
+		}
+		if err := tx.Model(&dbUser).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&resetToken).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		// 再設定前のセッションとリフレッシュトークンはすべて無効化
+		return signOutEverywhere(tx, resetToken.UserID)
+	})
+	if errors.Is(err, errInvalidToken) {
+		return &model.PasswordResetResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています。もう一度やり直してください",
+		}, nil
+	}
+	if errors.Is(err, errHashFailed) {
+		return &model.PasswordResetResponse{
+			Success: false,
+			Message: "パスワードの処理中にエラーが発生しました",
+		}, nil
+	}
+	if err != nil {
+		return &model.PasswordResetResponse{
+			Success: false,
+			Message: "パスワードの再設定中にエラーが発生しました",
+		}, nil
+	}
+	if len(fieldErrors) > 0 {
+		return &model.PasswordResetResponse{
+			Success:     false,
+			Message:     fieldErrors[0].Message,
+			FieldErrors: fieldErrors,
+		}, nil
+	}
+
+	// メールで本人確認できたので、ログインの失敗によるロックも解除する
+	r.loginSucceeded(ctx, dbUser.Email)
+
+	return &model.PasswordResetResponse{
+		Success: true,
+		Message: "パスワードを再設定しました。新しいパスワードでログインしてください",
+	}, nil
+}
+
+// VerifyEmail is the resolver for the verifyEmail field.
+func (r *mutationResolver) VerifyEmail(ctx context.Context, token string) (*model.VerifyEmailResponse, error) {
+	if token == "" {
+		return &model.VerifyEmailResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています",
+		}, nil
+	}
+
+	errInvalidToken := errors.New("invalid token")
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		// 同じトークンが同時に使われないよう行ロックを取る
+		var verificationToken database.EmailVerificationToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+			First(&verificationToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&database.User{}).Where("id = ? AND verified_at IS NULL", verificationToken.UserID).Update("verified_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&verificationToken).Update("used_at", now).Error
+	})
+	if errors.Is(err, errInvalidToken) {
+		return &model.VerifyEmailResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています。確認メールを再送信してください",
+		}, nil
+	}
+	if err != nil {
+		return &model.VerifyEmailResponse{
+			Success: false,
+			Message: "メールアドレスの確認中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.VerifyEmailResponse{
+		Success: true,
+		Message: "メールアドレスの確認が完了しました",
+	}, nil
+}
+
+// ResendVerificationEmail is the resolver for the resendVerificationEmail field.
+func (r *mutationResolver) ResendVerificationEmail(ctx context.Context, email string) (*model.VerifyEmailResponse, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return &model.VerifyEmailResponse{
+			Success: false,
+			Message: "メールアドレスを入力してください",
+		}, nil
+	}
+
+	// 登録の有無が分からないよう、未登録・確認済みの場合も同じメッセージを返す
+	sentResponse := &model.VerifyEmailResponse{
+		Success: true,
+		Message: "確認が済んでいない登録済みのメールアドレスの場合、確認メールを送信しました",
+	}
+
+	var dbUser database.User
+	if err := r.GORMDB.Where("email = ?", email).First(&dbUser).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return sentResponse, nil
+		}
+		return &model.VerifyEmailResponse{
+			Success: false,
+			Message: "確認メールの再送信中にエラーが発生しました",
+		}, nil
+	}
+	if dbUser.VerifiedAt != nil {
+		return sentResponse, nil
+	}
+
+	// 送信の失敗を返すと確認が済んでいない登録済みのメールアドレスだと分かってしまうため、ログに残すだけにする
+	if err := r.sendVerificationEmail(ctx, dbUser); err != nil {
+		log.Printf("確認メールの送信に失敗: %v", err)
+	}
+
+	return sentResponse, nil
+}
+
+// UnlockAccount is the resolver for the unlockAccount field.
+func (r *mutationResolver) UnlockAccount(ctx context.Context, token string) (*model.UnlockAccountResponse, error) {
+	if token == "" {
+		return &model.UnlockAccountResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています",
+		}, nil
+	}
+
+	errInvalidToken := errors.New("invalid token")
+	var dbUser database.User
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		// 同じトークンが同時に使われないよう行ロックを取る
+		var unlockToken database.AccountUnlockToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+			First(&unlockToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.First(&dbUser, unlockToken.UserID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&unlockToken).Update("used_at", time.Now()).Error
+	})
+	if errors.Is(err, errInvalidToken) {
+		return &model.UnlockAccountResponse{
+			Success: false,
+			Message: "リンクが無効か有効期限が切れています",
+		}, nil
+	}
+	if err != nil {
+		return &model.UnlockAccountResponse{
+			Success: false,
+			Message: "ロックの解除中にエラーが発生しました",
+		}, nil
+	}
+
+	// IPアドレスごとの制限は解除しない（他人による試行の可能性があるため）
+	if r.LoginGuard != nil {
+		if err := r.LoginGuard.Unlock(ctx, dbUser.Email); err != nil {
+			return &model.UnlockAccountResponse{
+				Success: false,
+				Message: "ロックの解除中にエラーが発生しました",
+			}, nil
+		}
+	}
+
+	return &model.UnlockAccountResponse{
+		Success: true,
+		Message: "アカウントのロックを解除しました。ログインしてください",
+	}, nil
+}
+
+// RevokeSession is the resolver for the revokeSession field.
+func (r *mutationResolver) RevokeSession(ctx context.Context, id string) (bool, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// 公開IDから自分のセッションを探して削除
+	var dbSessions []database.Session
+	if err := r.GORMDB.Where("user_id = ?", dbUser.ID).Find(&dbSessions).Error; err != nil {
+		return false, fmt.Errorf("セッション取得エラー: %v", err)
+	}
+
+	for _, dbSession := range dbSessions {
+		if dbSession.PublicID() != id {
+			continue
+		}
+		if err := r.GORMDB.Delete(&dbSession).Error; err != nil {
+			return false, fmt.Errorf("セッションの削除に失敗: %v", err)
+		}
+		return true, nil
+	}
+
+	return false, fmt.Errorf("セッションが見つかりません")
+}
+
+// RevokeOtherSessions is the resolver for the revokeOtherSessions field.
+func (r *mutationResolver) RevokeOtherSessions(ctx context.Context) (int32, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked, err := r.signOutOtherDevices(dbUser.ID, r.currentSessionID(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return int32(revoked), nil
+}
+
+// CreateAPIToken is the resolver for the createApiToken field.
+func (r *mutationResolver) CreateAPIToken(ctx context.Context, name string, scopes []model.APITokenScope, expiresAt *string) (*model.CreateAPITokenResponse, error) {
+	// 漏洩したトークンで新しいトークンを発行できないよう、セッションでのログインを必須にする
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &model.CreateAPITokenResponse{
+			Success: false,
+			Message: "トークン名を入力してください",
+		}, nil
+	}
+	if len([]rune(name)) > 100 {
+		return &model.CreateAPITokenResponse{
+			Success: false,
+			Message: "トークン名は100文字以内で入力してください",
+		}, nil
+	}
+	if len(scopes) == 0 {
+		return &model.CreateAPITokenResponse{
+			Success: false,
+			Message: "スコープを1つ以上指定してください",
+		}, nil
+	}
+
+	// 重複を除き、定義順に並べて保存する
+	scopeNames := make([]string, 0, len(scopes))
+	for _, scope := range model.AllAPITokenScope {
+		if slices.Contains(scopes, scope) {
+			scopeNames = append(scopeNames, string(scope))
+		}
+	}
+
+	dbToken := database.APIToken{
+		UserID: dbUser.ID,
+		Name:   name,
+		Scopes: strings.Join(scopeNames, ","),
+	}
+	if expiresAt != nil {
+		t, err := time.Parse(time.RFC3339, *expiresAt)
+		if err != nil {
+			return &model.CreateAPITokenResponse{
+				Success: false,
+				Message: "expiresAtはRFC3339形式で指定してください",
+			}, nil
+		}
+		if !t.After(time.Now()) {
+			return &model.CreateAPITokenResponse{
+				Success: false,
+				Message: "有効期限には未来の日時を指定してください",
+			}, nil
+		}
+		dbToken.ExpiresAt = &t
+	}
+
+	token, _, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	token = apiTokenPrefix + token
+	dbToken.TokenHash = hashToken(token)
+
+	if err := r.GORMDB.Create(&dbToken).Error; err != nil {
+		return &model.CreateAPITokenResponse{
+			Success: false,
+			Message: "APIトークンの作成中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.CreateAPITokenResponse{
+		Success:  true,
+		Message:  "APIトークンを作成しました。トークンはこの画面でのみ表示されます",
+		Token:    &token,
+		APIToken: toModelAPIToken(dbToken),
+	}, nil
+}
+
+// RevokeAPIToken is the resolver for the revokeApiToken field.
+func (r *mutationResolver) RevokeAPIToken(ctx context.Context, id string) (bool, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	tokenID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("無効なAPIトークンIDです")
+	}
+
+	// 他のユーザーのトークンは見つからないものとして扱う
+	result := r.GORMDB.Where("id = ? AND user_id = ?", uint(tokenID), dbUser.ID).Delete(&database.APIToken{})
+	if result.Error != nil {
+		return false, fmt.Errorf("APIトークンの削除に失敗: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, fmt.Errorf("APIトークンが見つかりません")
+	}
+	return true, nil
+}
+
+// ExportMyData is the resolver for the exportMyData field.
+func (r *mutationResolver) ExportMyData(ctx context.Context) (*model.DataExportResponse, error) {
+	// 漏洩したAPIトークンで個人データを持ち出されないよう、セッションまたはJWTでのログインを必須にする
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(dataExportTokenTTL)
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&database.DataExportToken{
+			UserID:    dbUser.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: expiresAt,
+		}).Error; err != nil {
+			return err
+		}
+		entry := database.NewAuditLog(r.ClientIP, GetHTTPRequest(ctx), dbUser.ID, database.AuditActionDataExportRequested)
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		return &model.DataExportResponse{
+			Success: false,
+			Message: "データのエクスポート中にエラーが発生しました",
+		}, nil
+	}
+
+	downloadURL := r.BaseURL + "/account/export?token=" + url.QueryEscape(token)
+	expiresAtStr := expiresAt.Format(timeLayout)
+	return &model.DataExportResponse{
+		Success:     true,
+		Message:     "ダウンロード用のリンクを発行しました。15分以内にダウンロードしてください",
+		DownloadURL: &downloadURL,
+		ExpiresAt:   &expiresAtStr,
+	}, nil
+}
+
+// DeleteMyAccount is the resolver for the deleteMyAccount field.
+func (r *mutationResolver) DeleteMyAccount(ctx context.Context, password string) (*model.DeleteAccountResponse, error) {
+	if err := r.requireSessionAuth(ctx); err != nil {
+		return nil, err
+	}
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 現在のパスワードで再認証
+	if err := r.passwordHasher().Verify(dbUser.Password, password); err != nil {
+		return &model.DeleteAccountResponse{
+			Success: false,
+			Message: "パスワードが正しくありません",
+		}, nil
+	}
+
+	// TODOやセッション、トークンは外部キーの ON DELETE CASCADE で削除される
+	// 監査記録は users への外部キーを持たないため削除後も残る
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		entry := database.NewAuditLog(r.ClientIP, GetHTTPRequest(ctx), dbUser.ID, database.AuditActionAccountDeleted)
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		return tx.Delete(dbUser).Error
+	})
+	if err != nil {
+		return &model.DeleteAccountResponse{
+			Success: false,
+			Message: "アカウントの削除中にエラーが発生しました",
+		}, nil
+	}
+
+	// このリクエストのセッションCookieも削除する
+	if err := r.endSession(ctx); err != nil {
+		log.Printf("セッションの破棄に失敗: %v", err)
+	}
+
+	return &model.DeleteAccountResponse{
+		Success: true,
+		Message: "アカウントを削除しました",
+	}, nil
+}
+
+// SetUserRole is the resolver for the setUserRole field.
+func (r *mutationResolver) SetUserRole(ctx context.Context, id string, role model.Role) (*model.AdminUserResponse, error) {
+	// 自分自身の降格で管理者がいなくならないようにする
+	dbUser, err := r.findManagedUser(ctx, id, false)
+	if err != nil {
+		return &model.AdminUserResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := r.GORMDB.Model(dbUser).Update("role", toDatabaseRole(role)).Error; err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "ロールの変更中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.AdminUserResponse{
+		Success: true,
+		Message: "ロールを変更しました",
+		User:    toModelUser(*dbUser),
+	}, nil
+}
+
+// DisableUser is the resolver for the disableUser field.
+func (r *mutationResolver) DisableUser(ctx context.Context, id string) (*model.AdminUserResponse, error) {
+	dbUser, err := r.findManagedUser(ctx, id, false)
+	if err != nil {
+		return &model.AdminUserResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	// 無効化と同時にすべての端末からログアウトさせる
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(dbUser).Update("disabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return signOutEverywhere(tx, dbUser.ID)
+	})
+	if err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "アカウントの無効化中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.AdminUserResponse{
+		Success: true,
+		Message: "アカウントを無効化しました",
+		User:    toModelUser(*dbUser),
+	}, nil
+}
+
+// EnableUser is the resolver for the enableUser field.
+func (r *mutationResolver) EnableUser(ctx context.Context, id string) (*model.AdminUserResponse, error) {
+	dbUser, err := r.findManagedUser(ctx, id, true)
+	if err != nil {
+		return &model.AdminUserResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := r.GORMDB.Model(dbUser).Update("disabled_at", nil).Error; err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "アカウントの有効化中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.AdminUserResponse{
+		Success: true,
+		Message: "アカウントを有効化しました",
+		User:    toModelUser(*dbUser),
+	}, nil
+}
+
+// ForcePasswordReset is the resolver for the forcePasswordReset field.
+func (r *mutationResolver) ForcePasswordReset(ctx context.Context, id string) (*model.AdminUserResponse, error) {
+	dbUser, err := r.findManagedUser(ctx, id, false)
+	if err != nil {
+		return &model.AdminUserResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	// 現在のパスワードを推測できない値に置き換え、再設定が済むまでログインできなくする
+	password, _, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := r.passwordHasher().Hash(password)
+	if err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "パスワードの処理中にエラーが発生しました",
+		}, nil
+	}
+
+	err = r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(dbUser).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		return signOutEverywhere(tx, dbUser.ID)
+	})
+	if err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "パスワードのリセット中にエラーが発生しました",
+		}, nil
+	}
+
+	if err := r.sendPasswordResetEmail(ctx, *dbUser); err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "パスワードをリセットしましたが、再設定メールの送信に失敗しました",
+			User:    toModelUser(*dbUser),
+		}, nil
+	}
+
+	return &model.AdminUserResponse{
+		Success: true,
+		Message: "パスワードをリセットし、再設定メールを送信しました",
+		User:    toModelUser(*dbUser),
+	}, nil
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, id string) (*model.AdminUserResponse, error) {
+	dbUser, err := r.findManagedUser(ctx, id, false)
+	if err != nil {
+		return &model.AdminUserResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	// TODOやセッション、トークンは外部キーの ON DELETE CASCADE で削除される
+	if err := r.GORMDB.Delete(dbUser).Error; err != nil {
+		return &model.AdminUserResponse{
+			Success: false,
+			Message: "ユーザーの削除中にエラーが発生しました",
+		}, nil
+	}
+
+	return &model.AdminUserResponse{
+		Success: true,
+		Message: "ユーザーを削除しました",
+		User:    toModelUser(*dbUser),
+	}, nil
+}
+
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
+	// 未ログインの場合はエラーではなくnullを返す
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, nil
+	}
+
+	return toModelUser(*dbUser), nil
+}
+
+// MySessions is the resolver for the mySessions field.
+func (r *queryResolver) MySessions(ctx context.Context) ([]*model.ActiveSession, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbSessions []database.Session
+	if err := r.GORMDB.Where("user_id = ? AND expires_at > ?", dbUser.ID, time.Now()).Order("last_seen_at DESC").Find(&dbSessions).Error; err != nil {
+		return nil, fmt.Errorf("セッション取得エラー: %v", err)
+	}
+
+	currentID := r.currentSessionID(ctx)
+	activeSessions := make([]*model.ActiveSession, 0, len(dbSessions))
+	for _, dbSession := range dbSessions {
+		activeSessions = append(activeSessions, toModelActiveSession(dbSession, currentID))
+	}
+	return activeSessions, nil
+}
+
+// APITokens is the resolver for the apiTokens field.
+func (r *queryResolver) APITokens(ctx context.Context) ([]*model.APIToken, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbTokens []database.APIToken
+	if err := r.GORMDB.Where("user_id = ?", dbUser.ID).Order("created_at DESC, id DESC").Find(&dbTokens).Error; err != nil {
+		return nil, fmt.Errorf("APIトークン取得エラー: %v", err)
+	}
+
+	apiTokens := make([]*model.APIToken, 0, len(dbTokens))
+	for _, dbToken := range dbTokens {
+		apiTokens = append(apiTokens, toModelAPIToken(dbToken))
+	}
+	return apiTokens, nil
+}
+
+// Todos is the resolver for the todos field.
+func (r *queryResolver) Todos(ctx context.Context, filter *model.TodoFilter, orderBy *model.TodoOrder) ([]*model.Todo, error) {
+	// ログイン中のユーザーのTODOのみ取得
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := applyTodoFilter(r.GORMDB.Preload("User").Where("user_id = ?", dbUser.ID), filter)
+	if err != nil {
+		return nil, err
+	}
+	query, err = applyTodoOrder(query, orderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbTodos []database.Todo
+	if err := query.Find(&dbTodos).Error; err != nil {
+		return nil, fmt.Errorf("TODO取得エラー: %v", err)
+	}
+
+	// レスポンス用のモデルに変換
+	todos := make([]*model.Todo, 0, len(dbTodos))
+	for _, dbTodo := range dbTodos {
+		todos = append(todos, toModelTodo(dbTodo))
+	}
+
+	return todos, nil
+}
+
+// TodosConnection is the resolver for the todosConnection field.
+func (r *queryResolver) TodosConnection(ctx context.Context, first *int32, after *string, last *int32, before *string, filter *model.TodoFilter) (*model.TodoConnection, error) {
+	// ログイン中のユーザーのTODOのみ対象
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := applyTodoFilter(r.GORMDB.Model(&database.Todo{}).Where("user_id = ?", dbUser.ID), filter)
+	if err != nil {
+		return nil, err
+	}
+	return paginateTodos(base, first, after, last, before)
+}
+
+// SearchTodos is the resolver for the searchTodos field.
+func (r *queryResolver) SearchTodos(ctx context.Context, query string, first *int32) ([]*model.TodoSearchResult, error) {
+	// ログイン中のユーザーのTODOのみ検索
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := 0
+	if first != nil {
+		limit = int(*first)
+	}
+
+	base := r.GORMDB.Model(&database.Todo{}).Where("user_id = ?", dbUser.ID)
+	return searchTodos(base, query, limit)
+}
+
+// AllTodos is the resolver for the allTodos field.
+func (r *queryResolver) AllTodos(ctx context.Context) ([]*model.Todo, error) {
+	// 運用者のみ（@hasRole）全ユーザーのTODOを取得できる
+	var dbTodos []database.Todo
+	if err := r.GORMDB.Preload("User").Order("created_at DESC").Find(&dbTodos).Error; err != nil {
+		return nil, fmt.Errorf("TODO取得エラー: %v", err)
+	}
+
+	// レスポンス用のモデルに変換
+	todos := make([]*model.Todo, 0, len(dbTodos))
+	for _, dbTodo := range dbTodos {
+		todos = append(todos, toModelTodo(dbTodo))
+	}
+
+	return todos, nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, first *int32, after *string, search *string) (*model.UserConnection, error) {
+	// 管理者のみ（@hasRole）全ユーザーを参照できる
+	return paginateUsers(applyUserSearch(r.GORMDB.Model(&database.User{}), search), first, after)
+}
+
+// TodoChanged is the resolver for the todoChanged field.
+func (r *subscriptionResolver) TodoChanged(ctx context.Context) (<-chan *model.TodoChangedEvent, error) {
+	dbUser, err := r.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.Broker == nil {
+		return nil, fmt.Errorf("リアルタイム更新は利用できません")
+	}
+
+	messages, err := r.Broker.Subscribe(ctx, todoTopic(dbUser.ID))
+	if err != nil {
+		return nil, fmt.Errorf("購読に失敗: %v", err)
+	}
+
+	events := make(chan *model.TodoChangedEvent, 1)
+	go func() {
+		defer close(events)
+		for payload := range messages {
+			event, err := toTodoChangedEvent(payload, *dbUser)
+			if err != nil {
+				log.Printf("TODOの変更の読み取りに失敗: %v", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Email is the resolver for the email field.
+func (r *userResolver) Email(ctx context.Context, obj *model.User) (*string, error) {
+	if obj.Self {
+		return &obj.Email, nil
+	}
+
+	// 他のユーザーのメールアドレスはエラーにせず null を返す（TODOの一覧などに含まれるため）
+	info := getAuthInfo(ctx)
+	if info == nil {
+		return nil, nil
+	}
+	if strconv.FormatUint(uint64(info.UserID), 10) != obj.ID && !r.hasRole(info.User, model.RoleAdmin) {
+		return nil, nil
+	}
+	return &obj.Email, nil
+}
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// Subscription returns SubscriptionResolver implementation.
+func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
+
+// User returns UserResolver implementation.
+func (r *Resolver) User() UserResolver { return &userResolver{r} }
 
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
@@ -0,0 +1,165 @@
+package graph
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchLimit = 20
+	// スニペットに含める文字数と、最初の一致箇所より前に残す文字数
+	snippetLength  = 80
+	snippetContext = 20
+)
+
+// 全文検索でヒットしたTODOのIDと関連度
+type todoSearchHit struct {
+	ID    uint
+	Score float64
+}
+
+// FULLTEXTインデックス (ngramパーサー) を使って関連度順にTODOを検索
+// base は絞り込み条件のみを持つクエリ
+func searchTodos(base *gorm.DB, query string, limit int) ([]*model.TodoSearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("検索キーワードを入力してください")
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	var hits []todoSearchHit
+	err := base.Session(&gorm.Session{}).
+		Select("id, MATCH(text) AGAINST(? IN NATURAL LANGUAGE MODE) AS score", query).
+		Where("MATCH(text) AGAINST(? IN NATURAL LANGUAGE MODE)", query).
+		Order("score DESC, id DESC").
+		Limit(limit).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, fmt.Errorf("TODO検索エラー: %v", err)
+	}
+	if len(hits) == 0 {
+		return []*model.TodoSearchResult{}, nil
+	}
+
+	ids := make([]uint, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+
+	var dbTodos []database.Todo
+	if err := base.Session(&gorm.Session{}).Preload("User").Where("id IN ?", ids).Find(&dbTodos).Error; err != nil {
+		return nil, fmt.Errorf("TODO取得エラー: %v", err)
+	}
+	todosByID := make(map[uint]database.Todo, len(dbTodos))
+	for _, dbTodo := range dbTodos {
+		todosByID[dbTodo.ID] = dbTodo
+	}
+
+	// 関連度順を保ったまま結果を組み立てる
+	results := make([]*model.TodoSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		dbTodo, ok := todosByID[hit.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, &model.TodoSearchResult{
+			Todo:    toModelTodo(dbTodo),
+			Score:   hit.Score,
+			Snippet: buildSnippet(dbTodo.Text, query),
+		})
+	}
+
+	return results, nil
+}
+
+// 検索語の一致箇所を <mark> で囲んだHTMLエスケープ済みのスニペットを作成
+func buildSnippet(text string, query string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// 小文字化で文字数が変わる場合は大文字小文字を区別して照合する
+		lower = runes
+	}
+
+	// 一致箇所を [start, end) の区間として集める
+	type span struct{ start, end int }
+	var spans []span
+	for _, term := range strings.Fields(query) {
+		termRunes := []rune(strings.ToLower(term))
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if equalRunes(lower[i:i+len(termRunes)], termRunes) {
+				spans = append(spans, span{i, i + len(termRunes)})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	// 重なっている区間をまとめる
+	var merged []span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			if s.end > merged[n-1].end {
+				merged[n-1].end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	// 最初の一致箇所の少し前から切り出す
+	start := 0
+	if len(merged) > 0 && merged[0].start > snippetContext {
+		start = merged[0].start - snippetContext
+	}
+	end := start + snippetLength
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, s := range merged {
+		if s.end <= start || s.start >= end {
+			continue
+		}
+		markStart, markEnd := max(s.start, start), min(s.end, end)
+		b.WriteString(html.EscapeString(string(runes[pos:markStart])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[markStart:markEnd])))
+		b.WriteString("</mark>")
+		pos = markEnd
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String()
+}
+
+func equalRunes(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if unicode.ToLower(a[i]) != b[i] {
+			return false
+		}
+	}
+	return true
+}
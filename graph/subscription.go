@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/graph/model"
+)
+
+// Broker で配信するTODOの変更（別のレプリカに届くこともあるためJSONにして送る）
+type todoChangedMessage struct {
+	Type   model.TodoChangeType `json:"type"`
+	TodoID uint                 `json:"todoId"`
+	Text   string               `json:"text,omitempty"`
+	Done   bool                 `json:"done,omitempty"`
+}
+
+// ユーザーのTODOの変更を配信するトピック
+func todoTopic(userID uint) string {
+	return "todos:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// TODOの変更を、所有者の todoChanged の購読者に配信する
+// 変更自体は完了しているため、配信に失敗してもログに残すだけにする
+func (r *Resolver) publishTodoChanged(ctx context.Context, changeType model.TodoChangeType, dbTodo database.Todo) {
+	if r.Broker == nil {
+		return
+	}
+
+	message := todoChangedMessage{Type: changeType, TodoID: dbTodo.ID}
+	if changeType != model.TodoChangeTypeDeleted {
+		message.Text = dbTodo.Text
+		message.Done = dbTodo.Done
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("TODOの変更の配信に失敗: %v", err)
+		return
+	}
+	if err := r.Broker.Publish(ctx, todoTopic(dbTodo.UserID), payload); err != nil {
+		log.Printf("TODOの変更の配信に失敗: %v", err)
+	}
+}
+
+// 配信されたメッセージを todoChanged のイベントに変換する（TODOの所有者は購読しているユーザー）
+func toTodoChangedEvent(payload []byte, owner database.User) (*model.TodoChangedEvent, error) {
+	var message todoChangedMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, err
+	}
+
+	event := &model.TodoChangedEvent{
+		Type:   message.Type,
+		TodoID: strconv.FormatUint(uint64(message.TodoID), 10),
+	}
+	if message.Type != model.TodoChangeTypeDeleted {
+		event.Todo = &model.Todo{
+			ID:   event.TodoID,
+			Text: message.Text,
+			Done: message.Done,
+			User: toModelUser(owner),
+		}
+	}
+	return event, nil
+}
+
+// WebsocketInit は WebSocket の接続開始時にユーザーを認証する（transport.Websocket の InitFunc）
+// ブラウザからはアップグレード時のリクエストのセッションCookieで、
+// それ以外のクライアントからは connection_init の Authorization（Bearer トークン）で認証する
+func (r *Resolver) WebsocketInit(ctx context.Context, initPayload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+	if header := initPayload.Authorization(); header != "" {
+		httpReq := GetHTTPRequest(ctx)
+		if httpReq == nil {
+			return ctx, nil, fmt.Errorf("認証が必要です")
+		}
+		req := httpReq.Clone(ctx)
+		req.Header.Set("Authorization", header)
+
+		var err error
+		ctx, err = r.Authenticate(ctx, req)
+		if err != nil {
+			return ctx, nil, err
+		}
+	}
+
+	// 購読できるのはログイン中のユーザーのデータのみのため、未ログインの接続は受け付けない
+	if getAuthInfo(ctx) == nil {
+		return ctx, nil, fmt.Errorf("認証が必要です")
+	}
+	return ctx, &initPayload, nil
+}
@@ -0,0 +1,39 @@
+package graph
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/suimi34/golang-graphql/database"
+)
+
+const (
+	// パスワード再設定トークンの有効期限
+	passwordResetTokenTTL = time.Hour
+	// メールアドレス確認トークンの有効期限
+	emailVerificationTokenTTL = 24 * time.Hour
+	// メールアドレス変更の確認トークンの有効期限
+	emailChangeTokenTTL = 24 * time.Hour
+	// データエクスポートのダウンロードリンクの有効期限
+	dataExportTokenTTL = 15 * time.Minute
+	// ロック解除トークンの有効期限
+	accountUnlockTokenTTL = time.Hour
+)
+
+// ランダムなトークンを生成し、平文とデータベース保存用のハッシュを返す
+func generateToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("トークンの生成に失敗: %v", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// トークンのハッシュ値（平文はデータベースに保存しない）
+func hashToken(token string) string {
+	return database.HashToken(token)
+}
@@ -0,0 +1,223 @@
+package graph
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/suimi34/golang-graphql/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 認証アプリに表示する発行者名
+	totpIssuer = "golang-graphql"
+	// パスワード確認後、確認コードを入力するまでの有効期限
+	twoFactorChallengeTTL = 5 * time.Minute
+	// 1回のログインで確認コードを試せる回数
+	maxTwoFactorAttempts = 5
+	// 発行するリカバリーコードの数
+	recoveryCodeCount = 10
+)
+
+const twoFactorRequiredMessage = "認証アプリに表示されている確認コードを入力してください"
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPの時間ステップの長さ（秒）
+const totpPeriod = 30
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// TOTPの確認コードを検証し、一致した時間ステップを返す（前後1ステップの時刻のずれを許容）
+func matchTOTPStep(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpPeriod
+	for step := current - int64(totpOpts.Skew); step <= current+int64(totpOpts.Skew); step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPの確認コードを検証し、使った時間ステップを記録する
+// 最後に受け付けたステップ以前のコードは、正しいコードでも再利用として拒否する
+func acceptTOTP(tx *gorm.DB, dbUser database.User, code string) (bool, error) {
+	step, ok := matchTOTPStep(*dbUser.TwoFactorSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// 同時に同じコードが送られても1回しか受け付けないよう、条件付きで更新する
+	result := tx.Model(&database.User{}).
+		Where("id = ? AND (two_factor_last_step IS NULL OR two_factor_last_step < ?)", dbUser.ID, step).
+		Update("two_factor_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// リカバリーコードを生成し、平文とデータベース保存用のハッシュを返す
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("リカバリーコードの生成に失敗: %v", err)
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// 入力されたリカバリーコードを保存時と同じ形式にそろえる（区切りや大文字小文字は問わない）
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// パスワードの確認が済んだログインを保留し、確認コードと一緒に送ってもらうトークンを返す
+func (r *Resolver) createTwoFactorChallenge(userID uint) (string, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = r.GORMDB.Create(&database.TwoFactorChallenge{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(twoFactorChallengeTTL),
+	}).Error
+	if err != nil {
+		return "", fmt.Errorf("ログインの保留に失敗: %v", err)
+	}
+	return token, nil
+}
+
+// 保留中のログインを確認コードまたはリカバリーコードで完了する
+// 完了できない場合は nil と利用者向けのメッセージを返す
+func (r *Resolver) completeTwoFactorChallenge(ctx context.Context, loginToken string, code string) (*database.User, string) {
+	var dbUser database.User
+	errExpired := errors.New("challenge expired")
+	errLocked := errors.New("account locked")
+	failed := false
+
+	err := r.GORMDB.Transaction(func(tx *gorm.DB) error {
+		// 同じログインで同時に試行されないよう行ロックを取る
+		var challenge database.TwoFactorChallenge
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", hashToken(loginToken), time.Now(), maxTwoFactorAttempts).
+			First(&challenge).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errExpired
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.First(&dbUser, challenge.UserID).Error; err != nil {
+			return err
+		}
+		if dbUser.TwoFactorEnabledAt == nil || dbUser.TwoFactorSecret == nil || dbUser.DisabledAt != nil {
+			return errExpired
+		}
+		// 確認コードの失敗でもアカウントはロックされる
+		if r.loginLocked(ctx, dbUser.Email) {
+			return errLocked
+		}
+
+		ok, err := r.verifySecondFactor(tx, dbUser, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// 試行回数は確定させたいので、エラーではなく nil を返してコミットする
+			failed = true
+			return tx.Model(&challenge).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+		}
+
+		return tx.Model(&challenge).Update("used_at", time.Now()).Error
+	})
+	if errors.Is(err, errExpired) {
+		return nil, "ログインの有効期限が切れました。もう一度ログインしてください"
+	}
+	if errors.Is(err, errLocked) {
+		r.loginFailed(ctx, dbUser.Email, &dbUser, loginFailureLocked)
+		return nil, loginLockedMessage
+	}
+	if err != nil {
+		return nil, "2段階認証の確認中にエラーが発生しました"
+	}
+	if failed {
+		r.loginFailed(ctx, dbUser.Email, &dbUser, loginFailureSecondFactor)
+		return nil, "確認コードが正しくありません"
+	}
+
+	r.loginSucceeded(ctx, dbUser.Email)
+	return &dbUser, ""
+}
+
+// 確認コード、または未使用のリカバリーコードを検証する
+// 確認コードは使った時間ステップを記録し、リカバリーコードは使用済みにする
+func (r *Resolver) verifySecondFactor(tx *gorm.DB, dbUser database.User, code string) (bool, error) {
+	ok, err := acceptTOTP(tx, dbUser, code)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	var recoveryCode database.TwoFactorRecoveryCode
+	err = tx.Where("user_id = ? AND code_hash = ? AND used_at IS NULL", dbUser.ID, hashToken(normalizeRecoveryCode(code))).
+		First(&recoveryCode).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Model(&recoveryCode).Update("used_at", time.Now()).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// リカバリーコードを作り直す（以前のコードは使えなくなる）
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&database.TwoFactorRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	rows := make([]database.TwoFactorRecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		rows = append(rows, database.TwoFactorRecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -4,6 +4,8 @@ import (
 	"embed"
 	"html/template"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gorilla/sessions"
 	"gorm.io/gorm"
@@ -13,7 +15,11 @@ type AuthHandler struct {
 	DB           *gorm.DB
 	Templates    *template.Template
 	Env          string
-	SessionStore *sessions.CookieStore
+	SessionStore sessions.Store
+	// ログイン画面に表示するSSOプロバイダー
+	OIDCProviders []OIDCProviderLink
+	// パスワードの入力欄に設定する最小文字数（サーバーの入力ルールに合わせる。0 の場合は設定しない）
+	PasswordMinLength int
 }
 
 type RegistrationData struct {
@@ -24,7 +30,7 @@ type RegistrationData struct {
 	ShowPlayground bool
 }
 
-func NewAuthHandler(db *gorm.DB, env string, templatesFS embed.FS, sessionStore *sessions.CookieStore) (*AuthHandler, error) {
+func NewAuthHandler(db *gorm.DB, env string, templatesFS embed.FS, sessionStore sessions.Store) (*AuthHandler, error) {
 	// embedされたテンプレートを読み込み
 	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
@@ -54,11 +60,13 @@ type LoginData struct {
 	Email          string
 	Error          string
 	ShowPlayground bool
+	OIDCProviders  []OIDCProviderLink
 }
 
 func (h *AuthHandler) ShowLoginForm(w http.ResponseWriter, r *http.Request) {
 	data := LoginData{
 		ShowPlayground: h.Env == "development",
+		OIDCProviders:  h.OIDCProviders,
 	}
 
 	if err := h.Templates.ExecuteTemplate(w, "login.html", data); err != nil {
@@ -66,3 +74,137 @@ func (h *AuthHandler) ShowLoginForm(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// Logout は POST /logout で受け付ける（GETで受け付けると他のサイトからログアウトさせられるため）
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// 他のサイトのフォームから送信されたリクエストは受け付けない
+	if !isSameOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// セッションを破棄してログイン画面に戻す
+	session, _ := h.SessionStore.Get(r, "session")
+	session.Values = map[interface{}]interface{}{}
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+type PasswordResetData struct {
+	Token             string
+	PasswordMinLength int
+	ShowPlayground    bool
+}
+
+func (h *AuthHandler) ShowForgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	data := PasswordResetData{
+		ShowPlayground: h.Env == "development",
+	}
+
+	if err := h.Templates.ExecuteTemplate(w, "forgot_password.html", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *AuthHandler) ShowResetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	// メールのリンクに含まれるトークンをフォームに引き継ぐ
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	data := PasswordResetData{
+		Token:             token,
+		PasswordMinLength: h.PasswordMinLength,
+		ShowPlayground:    h.Env == "development",
+	}
+
+	if err := h.Templates.ExecuteTemplate(w, "reset_password.html", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+type VerifyEmailData struct {
+	Token          string
+	ShowPlayground bool
+}
+
+func (h *AuthHandler) ShowVerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	// 確認メールのリンクに含まれるトークンを画面から verifyEmail に送る
+	data := VerifyEmailData{
+		Token:          r.URL.Query().Get("token"),
+		ShowPlayground: h.Env == "development",
+	}
+
+	if err := h.Templates.ExecuteTemplate(w, "verify_email.html", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+type UnlockAccountData struct {
+	Token          string
+	ShowPlayground bool
+}
+
+func (h *AuthHandler) ShowUnlockAccountPage(w http.ResponseWriter, r *http.Request) {
+	// ロック解除メールのリンクに含まれるトークンを画面から unlockAccount に送る
+	data := UnlockAccountData{
+		Token:          r.URL.Query().Get("token"),
+		ShowPlayground: h.Env == "development",
+	}
+
+	if err := h.Templates.ExecuteTemplate(w, "unlock_account.html", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+type ConfirmEmailChangeData struct {
+	Token          string
+	ShowPlayground bool
+}
+
+func (h *AuthHandler) ShowConfirmEmailChangePage(w http.ResponseWriter, r *http.Request) {
+	// 変更確認メールのリンクに含まれるトークンを画面から confirmEmailChange に送る
+	data := ConfirmEmailChangeData{
+		Token:          r.URL.Query().Get("token"),
+		ShowPlayground: h.Env == "development",
+	}
+
+	if err := h.Templates.ExecuteTemplate(w, "confirm_email_change.html", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// リクエストが同じオリジンのページから送信されたかを Origin（なければ Referer）ヘッダーで確認する
+// どちらもない場合は受け付けない（他のサイトのページは Referrer-Policy: no-referrer で Referer を送らせないことができる）
+func isSameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return false
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
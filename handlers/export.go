@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/suimi34/golang-graphql/clientip"
+	"github.com/suimi34/golang-graphql/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExportHandler は exportMyData で発行したリンクから個人データのアーカイブをダウンロードさせる
+type ExportHandler struct {
+	DB *gorm.DB
+	// 監査記録に残すリクエスト元のIPアドレスの求め方
+	ClientIP clientip.Resolver
+}
+
+// DataExport はダウンロードさせるアーカイブの内容
+type DataExport struct {
+	ExportedAt time.Time         `json:"exportedAt"`
+	Profile    DataExportProfile `json:"profile"`
+	Todos      []DataExportTodo  `json:"todos"`
+}
+
+type DataExportProfile struct {
+	ID               uint       `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Role             string     `json:"role"`
+	VerifiedAt       *time.Time `json:"verifiedAt"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
+
+type DataExportTodo struct {
+	ID        uint      `json:"id"`
+	Text      string    `json:"text"`
+	Done      bool      `json:"done"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func NewExportHandler(db *gorm.DB, clientIP clientip.Resolver) *ExportHandler {
+	return &ExportHandler{DB: db, ClientIP: clientIP}
+}
+
+// Download はリンクのトークンを検証し、プロフィールとすべてのTODOをJSONでダウンロードさせる
+// リンクは一度だけ使える
+func (h *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "リンクが無効か有効期限が切れています", http.StatusNotFound)
+		return
+	}
+
+	errInvalidToken := errors.New("invalid token")
+	var export DataExport
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// 同じリンクが同時に使われないよう行ロックを取る
+		var exportToken database.DataExportToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", database.HashToken(token), time.Now()).
+			First(&exportToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		export, err = buildDataExport(tx, exportToken.UserID)
+		// リンクの発行後にアカウントが削除された場合は、無効なリンクと同じように扱う
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&exportToken).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		entry := database.NewAuditLog(h.ClientIP, r, exportToken.UserID, database.AuditActionDataExportDownloaded)
+		return tx.Create(&entry).Error
+	})
+	if errors.Is(err, errInvalidToken) {
+		http.Error(w, "リンクが無効か有効期限が切れています", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errExportAccountDisabled) {
+		http.Error(w, "このアカウントは無効化されています", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("データエクスポートの作成に失敗: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("export-%d-%s.json", export.Profile.ID, export.ExportedAt.Format("20060102150405"))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		log.Printf("データエクスポートの書き込みに失敗: %v", err)
+	}
+}
+
+// 管理者に無効化されたユーザーのデータはエクスポートしない
+var errExportAccountDisabled = errors.New("account disabled")
+
+// ユーザーのプロフィールとすべてのTODOを集める
+func buildDataExport(tx *gorm.DB, userID uint) (DataExport, error) {
+	var dbUser database.User
+	if err := tx.First(&dbUser, userID).Error; err != nil {
+		return DataExport{}, err
+	}
+	if dbUser.DisabledAt != nil {
+		return DataExport{}, errExportAccountDisabled
+	}
+
+	var dbTodos []database.Todo
+	if err := tx.Where("user_id = ?", userID).Order("created_at ASC, id ASC").Find(&dbTodos).Error; err != nil {
+		return DataExport{}, err
+	}
+
+	export := DataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: DataExportProfile{
+			ID:               dbUser.ID,
+			Name:             dbUser.Name,
+			Email:            dbUser.Email,
+			Role:             dbUser.Role,
+			VerifiedAt:       dbUser.VerifiedAt,
+			TwoFactorEnabled: dbUser.TwoFactorEnabledAt != nil,
+			CreatedAt:        dbUser.CreatedAt,
+			UpdatedAt:        dbUser.UpdatedAt,
+		},
+		Todos: make([]DataExportTodo, 0, len(dbTodos)),
+	}
+	for _, dbTodo := range dbTodos {
+		export.Todos = append(export.Todos, DataExportTodo{
+			ID:        dbTodo.ID,
+			Text:      dbTodo.Text,
+			Done:      dbTodo.Done,
+			CreatedAt: dbTodo.CreatedAt,
+			UpdatedAt: dbTodo.UpdatedAt,
+		})
+	}
+	return export, nil
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/sessions"
+	"github.com/suimi34/golang-graphql/database"
+	"github.com/suimi34/golang-graphql/passwordhash"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// 認可リクエストの state などを保存するセッション名
+const oidcSessionName = "oidc"
+
+// 認可リクエストを開始してからコールバックまでの有効期限（秒）
+const oidcSessionMaxAge = 600
+
+// OIDCProviderConfig はOpenID Connectプロバイダーの設定
+type OIDCProviderConfig struct {
+	// URLに使う識別子（例: google）
+	Name         string
+	DisplayName  string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// OIDCProviderLink はログイン画面に表示するプロバイダー
+type OIDCProviderLink struct {
+	Name        string
+	DisplayName string
+}
+
+// OIDCProviderConfigsFromEnv は OIDC_PROVIDERS（カンマ区切りの識別子）と
+// OIDC_<識別子>_ISSUER / _CLIENT_ID / _CLIENT_SECRET / _DISPLAY_NAME / _SCOPES から設定を読み込む
+func OIDCProviderConfigsFromEnv() ([]OIDCProviderConfig, error) {
+	var configs []OIDCProviderConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := OIDCProviderConfig{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			IssuerURL:    os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		}
+		if config.IssuerURL == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER と %sCLIENT_ID を設定してください", prefix, prefix)
+		}
+		if config.DisplayName == "" {
+			config.DisplayName = name
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// プロバイダーごとの設定と、ディスカバリで取得した情報
+type oidcProvider struct {
+	config OIDCProviderConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// OIDCLoginChecker はプロバイダーで認証したユーザーにも、パスワードでのログインと同じ確認（ロック・2段階認証など）を行う
+type OIDCLoginChecker interface {
+	// ログインできない場合は利用者向けのメッセージを返す
+	// 2段階認証が有効な場合は、確認コードと一緒に送ってもらうトークンを返す
+	BeginExternalLogin(r *http.Request, dbUser database.User) (loginToken string, message string)
+}
+
+type OIDCHandler struct {
+	DB           *gorm.DB
+	SessionStore sessions.Store
+	// コールバックURLの組み立てに使うアプリケーションのURL
+	BaseURL string
+	// プロバイダーとの通信に使うHTTPクライアント（nil の場合は http.DefaultClient）
+	HTTPClient *http.Client
+	// 新規ユーザーのパスワードのハッシュ化（nil の場合は passwordhash.NewDefault を使う）
+	PasswordHasher passwordhash.Hasher
+	// セッションを作る前の確認（nil の場合は無効化されていないかのみ確認する）
+	LoginChecker OIDCLoginChecker
+
+	providers map[string]*oidcProvider
+	links     []OIDCProviderLink
+}
+
+func NewOIDCHandler(db *gorm.DB, sessionStore sessions.Store, baseURL string, configs []OIDCProviderConfig) *OIDCHandler {
+	h := &OIDCHandler{
+		DB:           db,
+		SessionStore: sessionStore,
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		providers:    make(map[string]*oidcProvider, len(configs)),
+	}
+	for _, config := range configs {
+		h.providers[config.Name] = &oidcProvider{config: config}
+		h.links = append(h.links, OIDCProviderLink{Name: config.Name, DisplayName: config.DisplayName})
+	}
+	return h
+}
+
+// Providers はログイン画面に表示するプロバイダーの一覧を返す
+func (h *OIDCHandler) Providers() []OIDCProviderLink {
+	return h.links
+}
+
+// Start はプロバイダーの認可エンドポイントにリダイレクトする
+func (h *OIDCHandler) Start(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.providers[r.PathValue("provider")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	oauth2Config, _, err := h.setup(r.Context(), p)
+	if err != nil {
+		log.Printf("OIDCプロバイダーの設定取得に失敗: %v", err)
+		http.Error(w, "認証プロバイダーに接続できません", http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// コールバックで照合するため、state・nonce・PKCEの検証値をセッションに保存
+	session, _ := h.SessionStore.Get(r, oidcSessionName)
+	session.Values["provider"] = p.config.Name
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["verifier"] = verifier
+	session.Options.MaxAge = oidcSessionMaxAge
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// Callback は認可コードをIDトークンと交換し、確認済みのメールアドレスでユーザーを紐付けてログインさせる
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.providers[r.PathValue("provider")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 保存した値を取り出し、認可リクエスト用のセッションは破棄する
+	session, _ := h.SessionStore.Get(r, oidcSessionName)
+	provider, _ := session.Values["provider"].(string)
+	state, _ := session.Values["state"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+	session.Values = map[interface{}]interface{}{}
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		http.Error(w, "認証がキャンセルされました", http.StatusUnauthorized)
+		return
+	}
+	if state == "" || provider != p.config.Name || query.Get("state") != state {
+		http.Error(w, "不正なリクエストです。もう一度ログインしてください", http.StatusBadRequest)
+		return
+	}
+
+	oauth2Config, verifierConfig, err := h.setup(r.Context(), p)
+	if err != nil {
+		log.Printf("OIDCプロバイダーの設定取得に失敗: %v", err)
+		http.Error(w, "認証プロバイダーに接続できません", http.StatusBadGateway)
+		return
+	}
+
+	ctx := h.clientContext(r.Context())
+	token, err := oauth2Config.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("認可コードの交換に失敗: %v", err)
+		http.Error(w, "認証に失敗しました", http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "認証に失敗しました", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := verifierConfig.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		log.Printf("IDトークンの検証に失敗: %v", err)
+		http.Error(w, "認証に失敗しました", http.StatusUnauthorized)
+		return
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "認証に失敗しました", http.StatusUnauthorized)
+		return
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		http.Error(w, "メールアドレスが確認済みのアカウントでログインしてください", http.StatusForbidden)
+		return
+	}
+
+	dbUser, err := h.findOrCreateUser(p.config.Name, idToken.Subject, claims.Email, claims.Name)
+	if errors.Is(err, errUnverifiedAccount) {
+		http.Error(w, "このメールアドレスのアカウントは確認が完了していません。確認メールのリンクからメールアドレスを確認してからログインしてください", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDCユーザーの紐付けに失敗: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if dbUser.DisabledAt != nil {
+		http.Error(w, "このアカウントは無効化されています", http.StatusForbidden)
+		return
+	}
+	if h.LoginChecker != nil {
+		loginToken, message := h.LoginChecker.BeginExternalLogin(r, *dbUser)
+		if message != "" {
+			http.Error(w, message, http.StatusForbidden)
+			return
+		}
+		// 2段階認証が有効な場合は、ログイン画面で確認コードを入力してもらう
+		// トークンがRefererやアクセスログに残らないよう、クエリではなくフラグメントで渡す
+		if loginToken != "" {
+			http.Redirect(w, r, "/login#two_factor="+url.QueryEscape(loginToken), http.StatusSeeOther)
+			return
+		}
+	}
+
+	// パスワードでのログインと同じく、新しいセッションIDでセッションを作成
+	if err := database.StartUserSession(h.SessionStore, r, w, *dbUser); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/todos", http.StatusSeeOther)
+}
+
+// メールアドレスを確認していない既存のアカウントには紐付けない
+var errUnverifiedAccount = errors.New("unverified account")
+
+// プロバイダーとユーザーを紐付ける
+// 紐付け済みでなければ確認済みのメールアドレスで既存のユーザーを探し、いなければ作成する
+func (h *OIDCHandler) findOrCreateUser(provider string, subject string, email string, name string) (*database.User, error) {
+	var dbUser database.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var identity database.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+		if err == nil {
+			return tx.First(&dbUser, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		err = tx.Where("email = ?", email).First(&dbUser).Error
+		switch {
+		case err == nil:
+			// メールアドレスを確認していないアカウントは、他人がそのアドレスで登録したものかもしれない
+			// 紐付けると登録した人のパスワードやセッションでもログインできてしまうため、自動では紐付けない
+			if dbUser.VerifiedAt == nil {
+				return errUnverifiedAccount
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// パスワードは推測できない値にしておき、必要ならパスワード再設定で設定してもらう
+			password, err := randomString()
+			if err != nil {
+				return err
+			}
+			hashedPassword, err := h.passwordHasher().Hash(password)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				name, _, _ = strings.Cut(email, "@")
+			}
+			dbUser = database.User{
+				Name:       name,
+				Email:      email,
+				Password:   hashedPassword,
+				VerifiedAt: &now,
+			}
+			if err := tx.Create(&dbUser).Error; err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&database.UserIdentity{
+			UserID:   dbUser.ID,
+			Provider: provider,
+			Subject:  subject,
+			Email:    email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dbUser, nil
+}
+
+// ディスカバリで取得したエンドポイントからOAuth2の設定とIDトークンの検証器を作成する
+// ディスカバリは初回のみ行い、失敗した場合は次のリクエストで再試行する
+func (h *OIDCHandler) setup(ctx context.Context, p *oidcProvider) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(h.clientContext(ctx), p.config.IssuerURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.provider = provider
+	}
+
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+
+	oauth2Config := &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     p.provider.Endpoint(),
+		RedirectURL:  h.BaseURL + "/auth/" + p.config.Name + "/callback",
+		Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+	}
+	verifier := p.provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	return oauth2Config, verifier, nil
+}
+
+// プロバイダーとの通信に HTTPClient を使うコンテキスト
+func (h *OIDCHandler) clientContext(ctx context.Context) context.Context {
+	if h.HTTPClient == nil {
+		return ctx
+	}
+	return oidc.ClientContext(ctx, h.HTTPClient)
+}
+
+// 新規ユーザーのパスワードのハッシュ化に使う Hasher
+func (h *OIDCHandler) passwordHasher() passwordhash.Hasher {
+	if h.PasswordHasher == nil {
+		return passwordhash.NewDefault()
+	}
+	return h.PasswordHasher
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -6,25 +6,29 @@ import (
 	"net/http"
 
 	"github.com/gorilla/sessions"
+	"github.com/suimi34/golang-graphql/database"
+	"gorm.io/gorm"
 )
 
 type TodoHandler struct {
+	DB           *gorm.DB
 	Templates    *template.Template
 	Env          string
-	SessionStore *sessions.CookieStore
+	SessionStore sessions.Store
 }
 
 type TodosData struct {
 	ShowPlayground bool
 }
 
-func NewTodoHandler(env string, templatesFS embed.FS, sessionStore *sessions.CookieStore) (*TodoHandler, error) {
+func NewTodoHandler(db *gorm.DB, env string, templatesFS embed.FS, sessionStore sessions.Store) (*TodoHandler, error) {
 	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
 		return nil, err
 	}
 
 	return &TodoHandler{
+		DB:           db,
 		Templates:    tmpl,
 		Env:          env,
 		SessionStore: sessionStore,
@@ -40,8 +44,15 @@ func (h *TodoHandler) ShowTodosPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// user_idがセッションに存在するか確認
-	userID, ok := session.Values["user_id"]
-	if !ok || userID == nil {
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// 削除されたユーザーや、管理者に無効化されたユーザーのセッションでは表示しない
+	var dbUser database.User
+	if err := h.DB.First(&dbUser, userID).Error; err != nil || dbUser.DisabledAt != nil {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
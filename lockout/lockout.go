@@ -0,0 +1,160 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Record はキー（アカウントやIPアドレス）ごとのログイン失敗の記録
+type Record struct {
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  time.Time
+}
+
+// Locked は now の時点でロック中かどうかを返す
+func (r Record) Locked(now time.Time) bool {
+	return now.Before(r.LockedUntil)
+}
+
+// Store は失敗の記録を保存する
+// 複数のレプリカで状態を共有する場合はデータベースに保存する実装を使う
+type Store interface {
+	// Get はキーの記録を返す。記録がない場合はゼロ値を返す
+	Get(ctx context.Context, key string) (Record, error)
+	// Fail は失敗を1回記録し、policy に従ってロックした後の記録を返す
+	// 同じキーへの同時の呼び出しでも回数を取りこぼさないこと
+	Fail(ctx context.Context, key string, now time.Time, policy Policy) (Record, error)
+	// Reset はキーの記録を削除する
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy はロックの条件
+type Policy struct {
+	// ロックを始める失敗回数
+	Threshold int
+	// 最初のロック時間。以降は失敗するごとに倍になる
+	BaseDelay time.Duration
+	// ロック時間の上限
+	MaxDelay time.Duration
+	// 最後の失敗からこの時間が過ぎたら失敗回数を数え直す
+	ResetAfter time.Duration
+}
+
+// Apply は失敗を1回加えた記録を返す。Store の実装から使う
+func (p Policy) Apply(record Record, now time.Time) Record {
+	if !record.LastFailedAt.IsZero() && now.Sub(record.LastFailedAt) > p.ResetAfter {
+		record = Record{}
+	}
+
+	record.Failures++
+	record.LastFailedAt = now
+	if record.Failures >= p.Threshold {
+		record.LockedUntil = now.Add(p.lockDuration(record.Failures))
+	}
+	return record
+}
+
+// 失敗回数に応じたロック時間（しきい値で BaseDelay、以降は倍々で MaxDelay まで）
+func (p Policy) lockDuration(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+var (
+	// DefaultAccountPolicy はアカウントごとのロック条件
+	DefaultAccountPolicy = Policy{
+		Threshold:  5,
+		BaseDelay:  time.Minute,
+		MaxDelay:   time.Hour,
+		ResetAfter: 24 * time.Hour,
+	}
+	// DefaultIPPolicy はIPアドレスごとのロック条件
+	// 同じネットワークから複数の利用者がログインすることを考慮してアカウントより緩くする
+	DefaultIPPolicy = Policy{
+		Threshold:  20,
+		BaseDelay:  time.Minute,
+		MaxDelay:   time.Hour,
+		ResetAfter: time.Hour,
+	}
+)
+
+// Guard はアカウントとIPアドレスの両方でログイン試行を制限する
+type Guard struct {
+	Store         Store
+	AccountPolicy Policy
+	IPPolicy      Policy
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{
+		Store:         store,
+		AccountPolicy: DefaultAccountPolicy,
+		IPPolicy:      DefaultIPPolicy,
+	}
+}
+
+// Check はアカウントまたはIPアドレスがロック中であれば、ロックが解除される日時を返す
+// ロックされていない場合はゼロ値を返す
+func (g *Guard) Check(ctx context.Context, email string, ip string, now time.Time) (time.Time, error) {
+	var lockedUntil time.Time
+	for _, key := range g.keys(email, ip) {
+		record, err := g.Store.Get(ctx, key)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("ログイン試行の記録の取得に失敗: %v", err)
+		}
+		if record.Locked(now) && record.LockedUntil.After(lockedUntil) {
+			lockedUntil = record.LockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+// Fail はログインの失敗を記録し、アカウントの記録を返す
+func (g *Guard) Fail(ctx context.Context, email string, ip string, now time.Time) (Record, error) {
+	account, err := g.Store.Fail(ctx, accountKey(email), now, g.AccountPolicy)
+	if err != nil {
+		return Record{}, fmt.Errorf("ログイン試行の記録に失敗: %v", err)
+	}
+	if ip != "" {
+		if _, err := g.Store.Fail(ctx, ipKey(ip), now, g.IPPolicy); err != nil {
+			return Record{}, fmt.Errorf("ログイン試行の記録に失敗: %v", err)
+		}
+	}
+	return account, nil
+}
+
+// Unlock はアカウントの失敗の記録を削除する
+// ログインに成功した時やロック解除のリンクが使われた時に呼ぶ（IPアドレスの記録は残す）
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	if err := g.Store.Reset(ctx, accountKey(email)); err != nil {
+		return fmt.Errorf("ログイン試行の記録の削除に失敗: %v", err)
+	}
+	return nil
+}
+
+// JustLocked はこの失敗でアカウントが初めてロックされたかどうかを返す
+func (g *Guard) JustLocked(account Record) bool {
+	return account.Failures == g.AccountPolicy.Threshold
+}
+
+func (g *Guard) keys(email string, ip string) []string {
+	keys := []string{accountKey(email)}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
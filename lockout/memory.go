@@ -0,0 +1,42 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore はプロセス内のメモリに記録を保存する Store の実装
+// レプリカ間で状態を共有しないため、単一プロセスでの運用やテストで使う
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, now time.Time, policy Policy) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := policy.Apply(s.records[key], now)
+	s.records[key] = record
+	return record, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message は送信するメール1通分の内容
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer はメールの送信手段を抽象化したインターフェース
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New は環境変数の設定に従って Mailer を作成する
+//
+// MAIL_TRANSPORT に smtp / file / memory を指定できる。未指定の場合、
+// production では smtp、それ以外ではローカルのファイル送信箱を使う。
+func New(env string) (Mailer, error) {
+	transport := strings.ToLower(os.Getenv("MAIL_TRANSPORT"))
+	if transport == "" {
+		if env == "production" {
+			transport = "smtp"
+		} else {
+			transport = "file"
+		}
+	}
+
+	switch transport {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     getEnv("SMTP_HOST", "127.0.0.1"),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getEnv("MAIL_FROM", "no-reply@example.com"),
+		}, nil
+	case "file":
+		return NewFileOutbox(getEnv("MAIL_OUTBOX_DIR", "tmp/outbox"))
+	case "memory":
+		return NewMemoryOutbox(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT: %s", transport)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
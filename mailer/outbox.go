@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOutbox は送信する代わりにメールをディレクトリに .eml ファイルとして保存する（開発用）
+type FileOutbox struct {
+	Dir string
+}
+
+func NewFileOutbox(dir string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("送信箱ディレクトリの作成に失敗: %w", err)
+	}
+	return &FileOutbox{Dir: dir}, nil
+}
+
+func (o *FileOutbox) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), sanitizeFilename(msg.To))
+	path := filepath.Join(o.Dir, name)
+	if err := os.WriteFile(path, buildMessage("outbox@localhost", msg), 0o600); err != nil {
+		return fmt.Errorf("メールの保存に失敗: %w", err)
+	}
+	return nil
+}
+
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// MemoryOutbox は送信したメールをメモリに保持する（テスト用）
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{}
+}
+
+func (o *MemoryOutbox) Send(ctx context.Context, msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages は送信済みのメールを古い順に返す
+func (o *MemoryOutbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// LastTo は指定した宛先に最後に送信されたメールを返す
+func (o *MemoryOutbox) LastTo(to string) (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if o.messages[i].To == to {
+			return o.messages[i], true
+		}
+	}
+	return Message{}, false
+}
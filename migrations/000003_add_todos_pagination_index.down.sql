@@ -0,0 +1,12 @@
+-- up で複合インデックスを作ると、InnoDB は外部キー用に自動で作った user_id インデックスを削除する
+-- その場合のみ同じ名前で作り直し、外部キーが使うインデックスが無くならないようにしてから削除する
+SET @fk_index_dropped := (
+    SELECT COUNT(*) = 0 FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = 'todos' AND index_name = 'user_id'
+);
+SET @recreate_fk_index := IF(@fk_index_dropped, 'CREATE INDEX user_id ON todos (user_id)', 'DO 0');
+PREPARE recreate_fk_index FROM @recreate_fk_index;
+EXECUTE recreate_fk_index;
+DEALLOCATE PREPARE recreate_fk_index;
+
+DROP INDEX idx_todos_user_created_at_id ON todos;
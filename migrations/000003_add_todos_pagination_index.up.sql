@@ -0,0 +1 @@
+CREATE INDEX idx_todos_user_created_at_id ON todos (user_id, created_at, id);
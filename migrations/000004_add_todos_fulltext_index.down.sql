@@ -0,0 +1 @@
+ALTER TABLE todos DROP INDEX ft_todos_text;
@@ -0,0 +1,2 @@
+-- 日本語も検索できるようにngramパーサーを使う
+ALTER TABLE todos ADD FULLTEXT INDEX ft_todos_text (text) WITH PARSER ngram;
@@ -0,0 +1,4 @@
+ALTER TABLE sessions
+    DROP COLUMN ip_address,
+    DROP COLUMN user_agent,
+    DROP COLUMN last_seen_at;
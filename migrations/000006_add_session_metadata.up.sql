@@ -0,0 +1,4 @@
+ALTER TABLE sessions
+    ADD COLUMN ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    ADD COLUMN user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
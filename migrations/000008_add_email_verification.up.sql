@@ -0,0 +1,16 @@
+ALTER TABLE users
+    ADD COLUMN verified_at TIMESTAMP NULL;
+
+-- 既存のユーザーは確認済みとして扱う
+UPDATE users SET verified_at = created_at WHERE verified_at IS NULL;
+
+CREATE TABLE email_verification_tokens (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_email_verification_tokens_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS two_factor_challenges;
+DROP TABLE IF EXISTS two_factor_recovery_codes;
+ALTER TABLE users
+    DROP COLUMN two_factor_secret,
+    DROP COLUMN two_factor_enabled_at;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS account_unlock_tokens;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS login_throttles;
@@ -0,0 +1,117 @@
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id は argon2id によるハッシュ化
+// ハッシュは $argon2id$v=19$m=<KiB>,t=<回数>,p=<並列数>$<ソルト>$<ハッシュ> の形式で保存する
+type Argon2id struct {
+	// 使用するメモリ（KiB）
+	Memory uint32
+	// 反復回数
+	Time uint32
+	// 並列数
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+var _ Algorithm = (*Argon2id)(nil)
+
+const argon2idPrefix = "$argon2id$"
+
+// NewArgon2id は OWASP の推奨値（19MiB、2回、並列数1）の Argon2id を返す
+func NewArgon2id() *Argon2id {
+	return &Argon2id{
+		Memory:     19 * 1024,
+		Time:       2,
+		Threads:    1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("ソルトの生成に失敗: %v", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2id) Verify(encoded string, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	// ハッシュに保存されたパラメータで計算する
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory ||
+		params.Time < a.Time ||
+		params.Threads != a.Threads ||
+		uint32(len(salt)) < a.SaltLength ||
+		uint32(len(key)) < a.KeyLength
+}
+
+func (a *Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+// ハッシュの文字列からパラメータ・ソルト・ハッシュを取り出す
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id のハッシュの形式が正しくありません")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id のハッシュの形式が正しくありません")
+	}
+	if version != argon2.Version {
+		return Argon2id{}, nil, nil, fmt.Errorf("対応していない argon2 のバージョンです: %d", version)
+	}
+
+	var params Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id のハッシュの形式が正しくありません")
+	}
+	if params.Time == 0 || params.Threads == 0 {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id のハッシュの形式が正しくありません")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id のハッシュの形式が正しくありません")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id のハッシュの形式が正しくありません")
+	}
+	return params, salt, key, nil
+}
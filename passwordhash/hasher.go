@@ -0,0 +1,76 @@
+package passwordhash
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMismatch はパスワードがハッシュと一致しない場合に返す
+var ErrMismatch = errors.New("パスワードが一致しません")
+
+// Hasher はパスワードのハッシュ化と検証を行う
+// ハッシュにはアルゴリズムとパラメータを含めて保存し、後からパラメータを変えても検証できるようにする
+type Hasher interface {
+	// Hash はパスワードをハッシュ化し、パラメータを含めた文字列を返す
+	Hash(password string) (string, error)
+	// Verify はパスワードがハッシュと一致するか検証する。一致しない場合は ErrMismatch を返す
+	Verify(encoded string, password string) error
+	// NeedsRehash はハッシュが古いアルゴリズムやパラメータで作られていて、作り直すべきかを返す
+	NeedsRehash(encoded string) bool
+}
+
+// Algorithm はひとつのハッシュアルゴリズムの実装
+type Algorithm interface {
+	Hasher
+	// Identifies はハッシュがこのアルゴリズムで作られたものかを返す
+	Identifies(encoded string) bool
+}
+
+// Upgrader は新しいハッシュを Preferred で作り、それ以外のアルゴリズムのハッシュも検証できる Hasher
+// Legacy のハッシュや Preferred の古いパラメータのハッシュは NeedsRehash が true になる
+type Upgrader struct {
+	Preferred Algorithm
+	Legacy    []Algorithm
+}
+
+var _ Hasher = (*Upgrader)(nil)
+
+// NewDefault は argon2id でハッシュ化し、既存の bcrypt のハッシュも検証できる Hasher を返す
+func NewDefault() *Upgrader {
+	return &Upgrader{
+		Preferred: NewArgon2id(),
+		Legacy:    []Algorithm{NewBcrypt()},
+	}
+}
+
+func (u *Upgrader) Hash(password string) (string, error) {
+	return u.Preferred.Hash(password)
+}
+
+func (u *Upgrader) Verify(encoded string, password string) error {
+	algorithm, err := u.algorithmFor(encoded)
+	if err != nil {
+		return err
+	}
+	return algorithm.Verify(encoded, password)
+}
+
+func (u *Upgrader) NeedsRehash(encoded string) bool {
+	if !u.Preferred.Identifies(encoded) {
+		return true
+	}
+	return u.Preferred.NeedsRehash(encoded)
+}
+
+// ハッシュを作ったアルゴリズムを探す
+func (u *Upgrader) algorithmFor(encoded string) (Algorithm, error) {
+	if u.Preferred.Identifies(encoded) {
+		return u.Preferred, nil
+	}
+	for _, algorithm := range u.Legacy {
+		if algorithm.Identifies(encoded) {
+			return algorithm, nil
+		}
+	}
+	return nil, fmt.Errorf("対応していないパスワードハッシュの形式です")
+}
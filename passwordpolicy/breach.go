@@ -0,0 +1,86 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 範囲検索に使うハッシュの先頭の文字数（Have I Been Pwned の Pwned Passwords と同じ5文字）
+const breachPrefixLength = 5
+
+// BreachList は漏洩したパスワードの SHA-1 ハッシュの一覧
+// Pwned Passwords の範囲検索と同じく先頭5文字ごとにまとめて持ち、平文のパスワードは保存しない
+type BreachList struct {
+	suffixes map[string][]string
+	size     int
+}
+
+// LoadBreachList はファイルから漏洩したパスワードの一覧を読み込む
+func LoadBreachList(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("漏洩パスワード一覧の読み込みに失敗: %v", err)
+	}
+	defer f.Close()
+
+	return ReadBreachList(f)
+}
+
+// ReadBreachList は1行に1件、SHA-1 ハッシュ（16進数）を書いた一覧を読み込む
+// Pwned Passwords と同じ「ハッシュ:件数」の形式の行や、# で始まるコメント行も受け付ける
+func ReadBreachList(r io.Reader) (*BreachList, error) {
+	list := &BreachList{suffixes: map[string][]string{}}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(strings.TrimSpace(hash))
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("漏洩パスワード一覧の%d行目が SHA-1 ハッシュではありません", lineNumber)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("漏洩パスワード一覧の%d行目が SHA-1 ハッシュではありません", lineNumber)
+		}
+
+		prefix := hash[:breachPrefixLength]
+		list.suffixes[prefix] = append(list.suffixes[prefix], hash[breachPrefixLength:])
+		list.size++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("漏洩パスワード一覧の読み込みに失敗: %v", err)
+	}
+
+	for _, suffixes := range list.suffixes {
+		sort.Strings(suffixes)
+	}
+	return list, nil
+}
+
+// Len は一覧に含まれるハッシュの件数を返す
+func (l *BreachList) Len() int {
+	return l.size
+}
+
+// Contains はパスワードが一覧に含まれているかを返す
+func (l *BreachList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := l.suffixes[hash[:breachPrefixLength]]
+	suffix := hash[breachPrefixLength:]
+	i := sort.SearchStrings(suffixes, suffix)
+	return i < len(suffixes) && suffixes[i] == suffix
+}
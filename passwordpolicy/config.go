@@ -0,0 +1,71 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ForEnv は環境ごとの既定のルールを返す
+// 本番環境では開発環境より長く、複数の種類の文字を含むパスワードを求める
+func ForEnv(env string) Policy {
+	policy := Default()
+	if env == "production" {
+		policy.MinLength = 10
+		policy.RequiredClasses = 2
+	}
+	return policy
+}
+
+// FromEnv は ForEnv の既定値を環境変数で上書きしたルールを返す
+//
+//	PASSWORD_MIN_LENGTH                最小の文字数
+//	PASSWORD_MAX_LENGTH                最大の文字数（0 で制限なし）
+//	PASSWORD_REQUIRED_CLASSES          含める必要がある文字の種類の数（0〜4）
+//	PASSWORD_DISALLOW_PERSONAL_INFO    メールアドレスや名前を含むパスワードを認めないか（true/false）
+//	PASSWORD_BREACH_LIST               漏洩したパスワードの SHA-1 ハッシュの一覧のファイル
+func FromEnv(env string) (Policy, error) {
+	policy := ForEnv(env)
+
+	ints := []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"PASSWORD_MIN_LENGTH", &policy.MinLength, -1},
+		{"PASSWORD_MAX_LENGTH", &policy.MaxLength, -1},
+		{"PASSWORD_REQUIRED_CLASSES", &policy.RequiredClasses, 4},
+	}
+	for _, setting := range ints {
+		raw := os.Getenv(setting.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 || (setting.max >= 0 && value > setting.max) {
+			return Policy{}, fmt.Errorf("%s の値が正しくありません: %q", setting.name, raw)
+		}
+		*setting.value = value
+	}
+	if policy.MaxLength > 0 && policy.MaxLength < policy.MinLength {
+		return Policy{}, fmt.Errorf("PASSWORD_MAX_LENGTH は PASSWORD_MIN_LENGTH 以上にしてください")
+	}
+
+	if raw := os.Getenv("PASSWORD_DISALLOW_PERSONAL_INFO"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return Policy{}, fmt.Errorf("PASSWORD_DISALLOW_PERSONAL_INFO の値が正しくありません: %q", raw)
+		}
+		policy.DisallowPersonalInfo = value
+	}
+
+	if path := os.Getenv("PASSWORD_BREACH_LIST"); path != "" {
+		list, err := LoadBreachList(path)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.Breached = list
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,137 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Code は違反したルールの種類
+type Code string
+
+const (
+	CodeTooShort                Code = "TOO_SHORT"
+	CodeTooLong                 Code = "TOO_LONG"
+	CodeMissingCharacterClasses Code = "MISSING_CHARACTER_CLASSES"
+	CodeContainsPersonalInfo    Code = "CONTAINS_PERSONAL_INFO"
+	CodeBreached                Code = "BREACHED"
+)
+
+// Violation はパスワードが満たしていないルールと利用者向けのメッセージ
+type Violation struct {
+	Code    Code
+	Message string
+}
+
+// Policy はパスワードの入力ルール
+type Policy struct {
+	// 最小の文字数
+	MinLength int
+	// 最大の文字数（0 の場合は制限しない）。ハッシュ化の負荷を抑えるため上限を設ける
+	MaxLength int
+	// 英小文字・英大文字・数字・記号のうち、含める必要がある種類の数
+	RequiredClasses int
+	// true の場合、メールアドレスや名前を含むパスワードを認めない
+	DisallowPersonalInfo bool
+	// 漏洩したパスワードの一覧（nil の場合は照合しない）
+	Breached *BreachList
+}
+
+// Default はこれまでと同じ6文字以上のルールに、上限と個人情報のチェックを加えたもの
+func Default() Policy {
+	return Policy{
+		MinLength:            6,
+		MaxLength:            128,
+		DisallowPersonalInfo: true,
+	}
+}
+
+// 個人情報とみなす最小の文字数（短すぎる名前で無関係なパスワードを拒否しないため）
+const minPersonalInfoLength = 3
+
+// Check はパスワードがルールを満たしているか検証し、違反したルールをすべて返す
+// personalInfo にはユーザーのメールアドレスや名前を渡す
+func (p Policy) Check(password string, personalInfo ...string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("パスワードは%d文字以上で入力してください", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("パスワードは%d文字以内で入力してください", p.MaxLength),
+		})
+	}
+
+	if p.RequiredClasses > 0 && characterClasses(password) < p.RequiredClasses {
+		violations = append(violations, Violation{
+			Code:    CodeMissingCharacterClasses,
+			Message: fmt.Sprintf("パスワードには英小文字・英大文字・数字・記号のうち%d種類以上を含めてください", p.RequiredClasses),
+		})
+	}
+
+	if p.DisallowPersonalInfo && containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, Violation{
+			Code:    CodeContainsPersonalInfo,
+			Message: "パスワードにメールアドレスや名前を含めないでください",
+		})
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, Violation{
+			Code:    CodeBreached,
+			Message: "このパスワードは過去に漏洩したことが確認されています。別のパスワードを入力してください",
+		})
+	}
+
+	return violations
+}
+
+// 英小文字・英大文字・数字・記号のうち含まれている種類の数
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			count++
+		}
+	}
+	return count
+}
+
+// パスワードがメールアドレス（ローカル部）や名前を含んでいるか（大文字・小文字は区別しない）
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	lowered := strings.ToLower(password)
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		candidates := []string{info}
+		if local, _, ok := strings.Cut(info, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= minPersonalInfoLength && strings.Contains(lowered, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// 購読者ごとにためておけるメッセージの数
+const subscriberBufferSize = 16
+
+// MemoryBroker はプロセス内で配信する Broker
+// 同じプロセスの購読者にのみ届くため、レプリカが1つの場合や開発環境で使う
+type MemoryBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan []byte]struct{}
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: map[string]map[chan []byte]struct{}{}}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[topic] {
+		// 受信が追いつかない購読者のために配信全体を止めないよう、あふれた分は捨てる
+		select {
+		case ch <- payload:
+		default:
+			log.Printf("購読者の受信が追いつかないためメッセージを破棄しました: %s", topic)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	ch := make(chan []byte, subscriberBufferSize)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan []byte]struct{}{}
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[topic], ch)
+		if len(b.subscribers[topic]) == 0 {
+			delete(b.subscribers, topic)
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
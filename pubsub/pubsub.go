@@ -0,0 +1,14 @@
+package pubsub
+
+import "context"
+
+// Broker はトピックごとにメッセージを配信する
+// 複数のレプリカで動かす場合は、Redis などを使ってレプリカ間で配信する実装に置き換える
+type Broker interface {
+	// Publish はトピックを購読しているすべての購読者にメッセージを送る
+	// 購読者の受信を待たずに返ること
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe はトピックを購読し、メッセージを受け取るチャネルを返す
+	// ctx が終了すると購読をやめてチャネルを閉じる
+	Subscribe(ctx context.Context, topic string) (<-chan []byte, error)
+}
@@ -7,6 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/extension"
@@ -14,9 +17,14 @@ import (
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gorilla/sessions"
+	"github.com/suimi34/golang-graphql/clientip"
 	"github.com/suimi34/golang-graphql/database"
 	"github.com/suimi34/golang-graphql/graph"
 	"github.com/suimi34/golang-graphql/handlers"
+	"github.com/suimi34/golang-graphql/lockout"
+	"github.com/suimi34/golang-graphql/mailer"
+	"github.com/suimi34/golang-graphql/passwordpolicy"
+	"github.com/suimi34/golang-graphql/pubsub"
 	"github.com/vektah/gqlparser/v2/ast"
 )
 
@@ -42,6 +50,20 @@ func main() {
 		log.Fatalf("GORM接続に失敗: %v", err)
 	}
 
+	// X-Forwarded-For に接続元を追記するプロキシの数（Cloud Run では 1、直接公開する場合は 0）
+	trustedProxyHops := 0
+	if hops := os.Getenv("TRUSTED_PROXY_HOPS"); hops != "" {
+		n, err := strconv.Atoi(hops)
+		if err != nil || n < 0 {
+			log.Fatalf("TRUSTED_PROXY_HOPS の値が正しくありません: %q", hops)
+		}
+		trustedProxyHops = n
+	}
+	if env == "production" && trustedProxyHops == 0 {
+		log.Println("警告: TRUSTED_PROXY_HOPS が 0 のため、接続元のアドレスをクライアントのIPアドレスとして扱います。プロキシやロードバランサーの背後で動かす場合は全員が同じIPアドレスとして数えられます")
+	}
+	clientIP := clientip.New(trustedProxyHops)
+
 	// セッションストアを初期化
 	sessionSecret := os.Getenv("SESSION_SECRET")
 	if sessionSecret == "" {
@@ -53,7 +75,9 @@ func main() {
 		sessionSecret = base64.StdEncoding.EncodeToString(secretBytes)
 		log.Println("警告: SESSION_SECRET が設定されていないため、ランダムシークレットを生成しました")
 	}
-	sessionStore := sessions.NewCookieStore([]byte(sessionSecret))
+	// セッションはsessionsテーブルに保存し、Cookieには署名済みのセッションIDのみを持たせる
+	sessionStore := database.NewSessionStore(gormDB, []byte(sessionSecret))
+	sessionStore.ClientIP = clientIP
 	sessionStore.Options = &sessions.Options{
 		Path:     "/",
 		MaxAge:   86400, // 24時間
@@ -61,13 +85,90 @@ func main() {
 		Secure:   env == "production",
 		SameSite: http.SameSiteLaxMode,
 	}
+	sessionStore.MaxAge(sessionStore.Options.MaxAge)
+
+	// 期限切れセッションを定期的に削除
+	sessionStore.StartCleanup(time.Hour)
+
+	// アクセストークン(JWT)の署名鍵を初期化
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		secretBytes := make([]byte, 32)
+		if _, err := rand.Read(secretBytes); err != nil {
+			log.Fatalf("JWT署名鍵の生成に失敗: %v", err)
+		}
+		jwtSecret = base64.StdEncoding.EncodeToString(secretBytes)
+		log.Println("警告: JWT_SECRET が設定されていないため、ランダムな署名鍵を生成しました")
+	}
+
+	// 最初の管理者を用意できるよう、管理者がまだいない場合のみ ADMIN_EMAILS（カンマ区切り）の
+	// メールアドレス確認済みのユーザーを管理者にする。以降の管理者の追加・解除は setUserRole で行う
+	var adminEmails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			adminEmails = append(adminEmails, email)
+		}
+	}
+	promoted, err := database.BootstrapAdmins(gormDB, adminEmails)
+	if err != nil {
+		log.Fatalf("管理者の設定に失敗: %v", err)
+	}
+	if promoted > 0 {
+		log.Printf("ADMIN_EMAILS のユーザー%d人を管理者にしました", promoted)
+	}
+
+	// メール送信を初期化（開発環境では送信せずファイルに保存する）
+	mail, err := mailer.New(env)
+	if err != nil {
+		log.Fatalf("メール送信の初期化に失敗: %v", err)
+	}
+
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+
+	// ログイン試行の記録先（複数のレプリカで共有するため、既定ではデータベースに保存する）
+	var throttleStore lockout.Store = database.NewLoginThrottleStore(gormDB)
+	if os.Getenv("LOGIN_THROTTLE_STORE") == "memory" {
+		throttleStore = lockout.NewMemoryStore()
+	}
+
+	// パスワードの入力ルール（環境ごとの既定値を PASSWORD_* の環境変数で上書きできる）
+	passwordPolicy, err := passwordpolicy.FromEnv(env)
+	if err != nil {
+		log.Fatalf("パスワードの入力ルールの設定に失敗: %v", err)
+	}
+	if passwordPolicy.Breached != nil {
+		log.Printf("漏洩パスワード一覧を読み込みました（%d件）", passwordPolicy.Breached.Len())
+	}
 
 	resolver := &graph.Resolver{
 		GORMDB:       gormDB,
 		SessionStore: sessionStore,
+		Mailer:       mail,
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		// メールアドレスを確認するまでログインさせない場合は true を設定
+		RequireEmailVerification: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		JWTSecret:                []byte(jwtSecret),
+		LoginGuard:               lockout.NewGuard(throttleStore),
+		PasswordPolicy:           &passwordPolicy,
+		// TODOの変更の配信（レプリカが複数の場合はレプリカ間で配信できる Broker に置き換える）
+		Broker:   pubsub.NewMemoryBroker(),
+		ClientIP: clientIP,
 	}
-	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{
+		Resolvers: resolver,
+		// @auth / @hasRole は AuthMiddleware で読み込んだユーザーをもとに判定する
+		Directives: resolver.Directives(),
+	}))
 
+	// サブスクリプション用の WebSocket（graphql-transport-ws）
+	// Upgrader の CheckOrigin を設定せず、Cookieで認証するため別オリジンからの接続は受け付けない
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		InitFunc:              resolver.WebsocketInit,
+	})
 	srv.AddTransport(transport.Options{})
 	srv.AddTransport(transport.GET{})
 	srv.AddTransport(transport.POST{})
@@ -84,9 +185,24 @@ func main() {
 	if err != nil {
 		log.Fatalf("認証ハンドラーの初期化に失敗: %v", err)
 	}
+	// 画面の入力欄もサーバーと同じ最小文字数にする
+	authHandler.PasswordMinLength = passwordPolicy.MinLength
+
+	// OpenID Connectでのログインを初期化（OIDC_PROVIDERS が空の場合は無効）
+	oidcConfigs, err := handlers.OIDCProviderConfigsFromEnv()
+	if err != nil {
+		log.Fatalf("OIDCプロバイダーの設定に失敗: %v", err)
+	}
+	oidcHandler := handlers.NewOIDCHandler(gormDB, sessionStore, resolver.BaseURL, oidcConfigs)
+	// パスワードでのログインと同じく、ロックと2段階認証を確認する
+	oidcHandler.LoginChecker = resolver
+	authHandler.OIDCProviders = oidcHandler.Providers()
+
+	// 個人データのエクスポートを初期化
+	exportHandler := handlers.NewExportHandler(gormDB, clientIP)
 
 	// Todoハンドラーを初期化
-	todoHandler, err := handlers.NewTodoHandler(env, templatesFS, sessionStore)
+	todoHandler, err := handlers.NewTodoHandler(gormDB, env, templatesFS, sessionStore)
 	if err != nil {
 		log.Fatalf("Todoハンドラーの初期化に失敗: %v", err)
 	}
@@ -100,6 +216,29 @@ func main() {
 	// ログインルート
 	http.HandleFunc("/login", authHandler.ShowLoginForm)
 
+	// ログアウトルート
+	http.HandleFunc("/logout", authHandler.Logout)
+
+	// パスワード再設定ルート
+	http.HandleFunc("/forgot-password", authHandler.ShowForgotPasswordForm)
+	http.HandleFunc("/reset-password", authHandler.ShowResetPasswordForm)
+
+	// メールアドレス確認ルート
+	http.HandleFunc("/verify", authHandler.ShowVerifyEmailPage)
+
+	// アカウントのロック解除ルート
+	http.HandleFunc("/unlock", authHandler.ShowUnlockAccountPage)
+
+	// メールアドレス変更の確認ルート
+	http.HandleFunc("/confirm-email", authHandler.ShowConfirmEmailChangePage)
+
+	// 個人データのダウンロードルート（exportMyData で発行したリンク）
+	http.HandleFunc("/account/export", exportHandler.Download)
+
+	// OpenID Connectログインルート
+	http.HandleFunc("/auth/{provider}/start", oidcHandler.Start)
+	http.HandleFunc("/auth/{provider}/callback", oidcHandler.Callback)
+
 	// Todo一覧ルート
 	http.HandleFunc("/todos", todoHandler.ShowTodosPage)
 
@@ -119,10 +258,8 @@ func main() {
 		log.Printf("Login available at http://localhost:%s/login", port)
 		log.Printf("Todo list available at http://localhost:%s/todos", port)
 	}
-	// GraphQLハンドラーにHTTPコンテキストを渡すラッパー
-	http.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
-		ctx := graph.WithHTTPContext(r.Context(), r, w)
-		srv.ServeHTTP(w, r.WithContext(ctx))
-	})
+	// GraphQLハンドラーにHTTPコンテキストと認証情報を渡すラッパー
+	// セッションCookieの代わりに Authorization: Bearer <APIトークン> でも認証できる
+	http.Handle("/query", resolver.AuthMiddleware(srv))
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
@@ -2,19 +2,46 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/gorilla/websocket"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/suimi34/golang-graphql/clientip"
 	"github.com/suimi34/golang-graphql/database"
 	"github.com/suimi34/golang-graphql/graph"
 	"github.com/suimi34/golang-graphql/graph/model"
+	"github.com/suimi34/golang-graphql/handlers"
+	"github.com/suimi34/golang-graphql/lockout"
+	"github.com/suimi34/golang-graphql/mailer"
+	"github.com/suimi34/golang-graphql/passwordhash"
+	"github.com/suimi34/golang-graphql/passwordpolicy"
+	"github.com/suimi34/golang-graphql/pubsub"
 )
 
 func TestGraphQLRequest(t *testing.T) {
@@ -26,15 +53,7 @@ func TestGraphQLRequest(t *testing.T) {
 	}
 
 	// テスト用データをGORMで挿入
-	testUser := database.User{
-		ID:       1,
-		Name:     "test",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-	if err := gormDB.Save(&testUser).Error; err != nil {
-		t.Fatalf("テストユーザーの挿入に失敗: %v", err)
-	}
+	createLoginUser(t, gormDB, 1, "test", "test@example.com", "password")
 
 	// テスト用TODOをGORMで挿入
 	testTodo := database.Todo{
@@ -53,15 +72,13 @@ func TestGraphQLRequest(t *testing.T) {
 		gormDB.Where("id IN ?", []uint{1, 123}).Delete(&database.User{})
 	}()
 
-	// gqlgenで生成されたExecutableSchemaからサーバーを作成
-	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{GORMDB: gormDB}}))
-	srv.AddTransport(transport.POST{})
-
-	// httptestでテスト用サーバーを作成
-	ts := httptest.NewServer(srv)
+	// todosはログイン中のユーザーのTODOのみ返すため、セッション付きのサーバーでログインしておく
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
 	defer ts.Close()
 
 	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "test@example.com", "password")
 
 	// GraphQLクエリのペイロードを用意
 	reqBody, err := json.Marshal(map[string]string{
@@ -92,7 +109,6 @@ func TestGraphQLRequest(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 
 	// HTTPクライアントを使用してリクエストを送信
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatalf("POSTリクエストの送信に失敗: %v", err)
@@ -141,15 +157,7 @@ func TestCreateTodoMutation(t *testing.T) {
 	}
 
 	// テスト用ユーザーデータをGORMで挿入
-	testUser := database.User{
-		ID:       123,
-		Name:     "Test User",
-		Email:    "testuser@example.com",
-		Password: "password",
-	}
-	if err := gormDB.Save(&testUser).Error; err != nil {
-		t.Fatalf("テストユーザーの挿入に失敗: %v", err)
-	}
+	createLoginUser(t, gormDB, 123, "Test User", "testuser@example.com", "password")
 
 	// テスト終了後にGORMでクリーンアップ
 	defer func() {
@@ -157,17 +165,17 @@ func TestCreateTodoMutation(t *testing.T) {
 		gormDB.Where("id IN ?", []uint{1, 123}).Delete(&database.User{})
 	}()
 
-	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{GORMDB: gormDB}}))
-	srv.AddTransport(transport.POST{})
-
-	ts := httptest.NewServer(srv)
+	// createTodoはセッションのユーザーで作成するため、ログインしておく
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
 	defer ts.Close()
 
 	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "testuser@example.com", "password")
 
 	mutation := `
 		mutation {
-			createTodo(input: {text: "New Todo Item", userId: "123"}) {
+			createTodo(input: {text: "New Todo Item"}) {
 				id
 				text
 				done
@@ -191,7 +199,6 @@ func TestCreateTodoMutation(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatalf("POSTリクエストの送信に失敗: %v", err)
@@ -227,3 +234,3222 @@ func TestCreateTodoMutation(t *testing.T) {
 	}
 	assert.Equal(t, int64(1), count, "TODOがデータベースに作成されている必要があります")
 }
+
+// セッション付きのテスト用GraphQLサーバーを作成
+func newSessionTestServer(t *testing.T, resolver *graph.Resolver) *httptest.Server {
+	t.Helper()
+
+	if resolver.SessionStore == nil {
+		resolver.SessionStore = sessions.NewCookieStore([]byte("test-session-secret"))
+	}
+	return httptest.NewServer(newGraphQLHandler(resolver))
+}
+
+// server.go の /query と同じように認証ミドルウェアとディレクティブを設定したGraphQLハンドラーを作成
+func newGraphQLHandler(resolver *graph.Resolver) http.Handler {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{
+		Resolvers:  resolver,
+		Directives: resolver.Directives(),
+	}))
+	srv.AddTransport(transport.Websocket{InitFunc: resolver.WebsocketInit})
+	srv.AddTransport(transport.POST{})
+
+	return resolver.AuthMiddleware(srv)
+}
+
+// Cookieを保持するHTTPクライアントを作成
+func newCookieClient(t *testing.T) *http.Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("CookieJarの作成に失敗: %v", err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// Authorizationヘッダーを付けて送信するトランスポート
+type bearerTransport struct {
+	token string
+}
+
+func (b bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// APIトークンで認証するHTTPクライアントを作成
+func newBearerClient(token string) *http.Client {
+	return &http.Client{Transport: bearerTransport{token: token}}
+}
+
+// GraphQLリクエストを送信してレスポンスボディを返す
+func postGraphQL(t *testing.T, client *http.Client, url string, query string, variables map[string]interface{}) []byte {
+	t.Helper()
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		t.Fatalf("リクエストボディの生成に失敗: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("POSTリクエストの作成に失敗: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("POSTリクエストの送信に失敗: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("レスポンスの読み込みに失敗: %v", err)
+	}
+	t.Logf("レスポンス: %s", string(body))
+	return body
+}
+
+// loginUserミューテーションでログインしてセッションCookieを取得
+func loginAs(t *testing.T, client *http.Client, url string, email string, password string) {
+	t.Helper()
+
+	body := postGraphQL(t, client, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginUser(input: $input) {
+				success
+				message
+			}
+		}`, map[string]interface{}{
+		"input": map[string]interface{}{"email": email, "password": password},
+	})
+
+	var res struct {
+		Data struct {
+			LoginUser model.LoginUserResponse `json:"loginUser"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !res.Data.LoginUser.Success {
+		t.Fatalf("ログインに失敗: %s", res.Data.LoginUser.Message)
+	}
+}
+
+// ログイン可能なテストユーザーを作成
+func createLoginUser(t *testing.T, gormDB *gorm.DB, id uint, name string, email string, password string) database.User {
+	t.Helper()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("パスワードのハッシュ化に失敗: %v", err)
+	}
+
+	user := database.User{
+		ID:       id,
+		Name:     name,
+		Email:    email,
+		Password: string(hashedPassword),
+	}
+	if err := gormDB.Save(&user).Error; err != nil {
+		t.Fatalf("テストユーザーの挿入に失敗: %v", err)
+	}
+	return user
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+func TestTodoMutationsByOwner(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 201, "Owner", "owner@example.com", "password")
+	testTodo := database.Todo{ID: 201, Text: "owner todo", UserID: 201}
+	if err := gormDB.Save(&testTodo).Error; err != nil {
+		t.Fatalf("テストTODOの挿入に失敗: %v", err)
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{201}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{201}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "owner@example.com", "password")
+
+	// テキストと完了状態を更新
+	body := postGraphQL(t, client, url, `
+		mutation {
+			updateTodo(id: "201", input: {text: "updated todo", done: true}) {
+				id
+				text
+				done
+			}
+		}`, nil)
+
+	var updateRes struct {
+		Data struct {
+			UpdateTodo model.Todo `json:"updateTodo"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &updateRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, updateRes.Errors)
+	assert.Equal(t, "updated todo", updateRes.Data.UpdateTodo.Text)
+	assert.Equal(t, true, updateRes.Data.UpdateTodo.Done)
+
+	// 完了状態を反転
+	body = postGraphQL(t, client, url, `mutation { toggleTodo(id: "201") { id done } }`, nil)
+
+	var toggleRes struct {
+		Data struct {
+			ToggleTodo model.Todo `json:"toggleTodo"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &toggleRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, toggleRes.Errors)
+	assert.Equal(t, false, toggleRes.Data.ToggleTodo.Done)
+
+	// 削除
+	body = postGraphQL(t, client, url, `mutation { deleteTodo(id: "201") }`, nil)
+
+	var deleteRes struct {
+		Data struct {
+			DeleteTodo string `json:"deleteTodo"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &deleteRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, deleteRes.Errors)
+	assert.Equal(t, "201", deleteRes.Data.DeleteTodo)
+
+	var count int64
+	if err := gormDB.Model(&database.Todo{}).Where("id = ?", 201).Count(&count).Error; err != nil {
+		t.Fatalf("TODOカウント取得に失敗: %v", err)
+	}
+	assert.Equal(t, int64(0), count, "TODOがデータベースから削除されている必要があります")
+}
+
+func TestTodoMutationsForbiddenForOtherUser(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 202, "Owner", "owner2@example.com", "password")
+	createLoginUser(t, gormDB, 203, "Other", "other@example.com", "password")
+	testTodo := database.Todo{ID: 202, Text: "owner todo", UserID: 202}
+	if err := gormDB.Save(&testTodo).Error; err != nil {
+		t.Fatalf("テストTODOの挿入に失敗: %v", err)
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{202, 203}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{202, 203}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "other@example.com", "password")
+
+	mutations := []string{
+		`mutation { updateTodo(id: "202", input: {text: "hijacked"}) { id } }`,
+		`mutation { toggleTodo(id: "202") { id } }`,
+		`mutation { deleteTodo(id: "202") }`,
+	}
+	for _, mutation := range mutations {
+		body := postGraphQL(t, client, url, mutation, nil)
+
+		var res struct {
+			Errors []graphQLError `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		if assert.NotEmpty(t, res.Errors) {
+			assert.Equal(t, "このTODOを操作する権限がありません", res.Errors[0].Message)
+		}
+	}
+
+	// 他人のTODOが変更されていないことを確認
+	var dbTodo database.Todo
+	if err := gormDB.First(&dbTodo, 202).Error; err != nil {
+		t.Fatalf("TODOが削除されています: %v", err)
+	}
+	assert.Equal(t, "owner todo", dbTodo.Text)
+	assert.Equal(t, false, dbTodo.Done)
+}
+
+func TestTodosQueryScopedToSessionUser(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 204, "Alice", "alice@example.com", "password")
+	createLoginUser(t, gormDB, 205, "Operator", "operator@example.com", "password")
+	testTodos := []database.Todo{
+		{ID: 204, Text: "alice todo", UserID: 204},
+		{ID: 205, Text: "operator todo", UserID: 205},
+	}
+	for _, testTodo := range testTodos {
+		if err := gormDB.Save(&testTodo).Error; err != nil {
+			t.Fatalf("テストTODOの挿入に失敗: %v", err)
+		}
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{204, 205}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{204, 205}).Delete(&database.User{})
+	}()
+
+	gormDB.Model(&database.User{}).Where("id = ?", 205).Update("role", database.RoleAdmin)
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+
+	type todosResponse struct {
+		Data struct {
+			Todos    []model.Todo `json:"todos"`
+			AllTodos []model.Todo `json:"allTodos"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+
+	// 未ログインでは取得できない
+	var anonymousRes todosResponse
+	body := postGraphQL(t, newCookieClient(t), url, `{ todos { id } }`, nil)
+	if err := json.Unmarshal(body, &anonymousRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.NotEmpty(t, anonymousRes.Errors)
+	assert.Empty(t, anonymousRes.Data.Todos)
+
+	// 一般ユーザーには自分のTODOのみ返し、allTodosは拒否する
+	aliceClient := newCookieClient(t)
+	loginAs(t, aliceClient, url, "alice@example.com", "password")
+
+	var aliceRes todosResponse
+	body = postGraphQL(t, aliceClient, url, `{ todos { id text } }`, nil)
+	if err := json.Unmarshal(body, &aliceRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, aliceRes.Errors)
+	if assert.Len(t, aliceRes.Data.Todos, 1) {
+		assert.Equal(t, "204", aliceRes.Data.Todos[0].ID)
+	}
+
+	var aliceAllRes todosResponse
+	body = postGraphQL(t, aliceClient, url, `{ allTodos { id } }`, nil)
+	if err := json.Unmarshal(body, &aliceAllRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotEmpty(t, aliceAllRes.Errors) {
+		assert.Equal(t, "この操作を行う権限がありません", aliceAllRes.Errors[0].Message)
+	}
+
+	// 運用者はallTodosで全ユーザーのTODOを取得できる
+	operatorClient := newCookieClient(t)
+	loginAs(t, operatorClient, url, "operator@example.com", "password")
+
+	var operatorRes todosResponse
+	body = postGraphQL(t, operatorClient, url, `{ allTodos { id } }`, nil)
+	if err := json.Unmarshal(body, &operatorRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, operatorRes.Errors)
+
+	ids := []string{}
+	for _, todo := range operatorRes.Data.AllTodos {
+		ids = append(ids, todo.ID)
+	}
+	assert.Contains(t, ids, "204")
+	assert.Contains(t, ids, "205")
+}
+
+func TestTodosConnectionPagination(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 206, "Pager", "pager@example.com", "password")
+
+	// 作成日時が同じTODOを含めて5件挿入（新しい順: 215, 214, 213, 212, 211）
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	createdAts := []time.Time{base, base.Add(time.Minute), base.Add(time.Minute), base.Add(2 * time.Minute), base.Add(3 * time.Minute)}
+	for i, createdAt := range createdAts {
+		testTodo := database.Todo{ID: uint(211 + i), Text: fmt.Sprintf("todo %d", i+1), UserID: 206, CreatedAt: createdAt}
+		if err := gormDB.Save(&testTodo).Error; err != nil {
+			t.Fatalf("テストTODOの挿入に失敗: %v", err)
+		}
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{206}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{206}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "pager@example.com", "password")
+
+	query := `
+		query Todos($first: Int, $after: String, $last: Int, $before: String) {
+			todosConnection(first: $first, after: $after, last: $last, before: $before) {
+				edges { cursor node { id } }
+				pageInfo { hasNextPage hasPreviousPage startCursor endCursor }
+			}
+		}`
+
+	fetch := func(variables map[string]interface{}) model.TodoConnection {
+		body := postGraphQL(t, client, url, query, variables)
+
+		var res struct {
+			Data struct {
+				TodosConnection model.TodoConnection `json:"todosConnection"`
+			} `json:"data"`
+			Errors []graphQLError `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		assert.Empty(t, res.Errors)
+		return res.Data.TodosConnection
+	}
+
+	nodeIDs := func(conn model.TodoConnection) []string {
+		ids := []string{}
+		for _, edge := range conn.Edges {
+			ids = append(ids, edge.Node.ID)
+		}
+		return ids
+	}
+
+	// 先頭から2件ずつ進む
+	page1 := fetch(map[string]interface{}{"first": 2})
+	assert.Equal(t, []string{"215", "214"}, nodeIDs(page1))
+	assert.True(t, page1.PageInfo.HasNextPage)
+	assert.False(t, page1.PageInfo.HasPreviousPage)
+
+	page2 := fetch(map[string]interface{}{"first": 2, "after": *page1.PageInfo.EndCursor})
+	assert.Equal(t, []string{"213", "212"}, nodeIDs(page2))
+	assert.True(t, page2.PageInfo.HasNextPage)
+	assert.True(t, page2.PageInfo.HasPreviousPage)
+
+	page3 := fetch(map[string]interface{}{"first": 2, "after": *page2.PageInfo.EndCursor})
+	assert.Equal(t, []string{"211"}, nodeIDs(page3))
+	assert.False(t, page3.PageInfo.HasNextPage)
+
+	// 末尾側から戻る
+	back := fetch(map[string]interface{}{"last": 2, "before": *page3.PageInfo.StartCursor})
+	assert.Equal(t, []string{"213", "212"}, nodeIDs(back))
+	assert.True(t, back.PageInfo.HasPreviousPage)
+	assert.True(t, back.PageInfo.HasNextPage)
+}
+
+func TestTodosQueryFilterAndOrder(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 207, "Filter", "filter@example.com", "password")
+
+	base := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	testTodos := []database.Todo{
+		{ID: 221, Text: "buy milk", Done: false, UserID: 207, CreatedAt: base},
+		{ID: 222, Text: "write report", Done: true, UserID: 207, CreatedAt: base.Add(24 * time.Hour)},
+		{ID: 223, Text: "buy 100% juice", Done: false, UserID: 207, CreatedAt: base.Add(48 * time.Hour)},
+	}
+	for _, testTodo := range testTodos {
+		if err := gormDB.Save(&testTodo).Error; err != nil {
+			t.Fatalf("テストTODOの挿入に失敗: %v", err)
+		}
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{207}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{207}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "filter@example.com", "password")
+
+	query := `
+		query Todos($filter: TodoFilter, $orderBy: TodoOrder) {
+			todos(filter: $filter, orderBy: $orderBy) { id }
+		}`
+
+	fetchIDs := func(variables map[string]interface{}) []string {
+		body := postGraphQL(t, client, url, query, variables)
+
+		var res struct {
+			Data struct {
+				Todos []model.Todo `json:"todos"`
+			} `json:"data"`
+			Errors []graphQLError `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		assert.Empty(t, res.Errors)
+
+		ids := []string{}
+		for _, todo := range res.Data.Todos {
+			ids = append(ids, todo.ID)
+		}
+		return ids
+	}
+
+	// 未完了のみ
+	assert.Equal(t, []string{"223", "221"}, fetchIDs(map[string]interface{}{
+		"filter": map[string]interface{}{"done": false},
+	}))
+
+	// 完了のみ
+	assert.Equal(t, []string{"222"}, fetchIDs(map[string]interface{}{
+		"filter": map[string]interface{}{"done": true},
+	}))
+
+	// 作成日時の範囲
+	assert.Equal(t, []string{"222"}, fetchIDs(map[string]interface{}{
+		"filter": map[string]interface{}{
+			"createdAfter":  base.Add(time.Hour).Format(time.RFC3339),
+			"createdBefore": base.Add(47 * time.Hour).Format(time.RFC3339),
+		},
+	}))
+
+	// ワイルドカード文字はそのまま検索される
+	assert.Equal(t, []string{"223"}, fetchIDs(map[string]interface{}{
+		"filter": map[string]interface{}{"textContains": "100%"},
+	}))
+
+	// テキストの昇順
+	assert.Equal(t, []string{"223", "221", "222"}, fetchIDs(map[string]interface{}{
+		"orderBy": map[string]interface{}{"field": "TEXT", "direction": "ASC"},
+	}))
+}
+
+func TestSearchTodosFullText(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 208, "Searcher", "searcher@example.com", "password")
+	createLoginUser(t, gormDB, 209, "Stranger", "stranger@example.com", "password")
+	testTodos := []database.Todo{
+		{ID: 231, Text: "牛乳を買う", UserID: 208},
+		{ID: 232, Text: "レポートを書く", UserID: 208},
+		{ID: 233, Text: "牛乳を買う", UserID: 209},
+	}
+	for _, testTodo := range testTodos {
+		if err := gormDB.Save(&testTodo).Error; err != nil {
+			t.Fatalf("テストTODOの挿入に失敗: %v", err)
+		}
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{208, 209}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{208, 209}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "searcher@example.com", "password")
+
+	body := postGraphQL(t, client, url, `
+		query Search($query: String!) {
+			searchTodos(query: $query) {
+				todo { id text }
+				score
+				snippet
+			}
+		}`, map[string]interface{}{"query": "牛乳"})
+
+	var res struct {
+		Data struct {
+			SearchTodos []model.TodoSearchResult `json:"searchTodos"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, res.Errors)
+
+	// 自分のTODOのみがヒットし、一致箇所が強調される
+	if assert.Len(t, res.Data.SearchTodos, 1) {
+		assert.Equal(t, "231", res.Data.SearchTodos[0].Todo.ID)
+		assert.Greater(t, res.Data.SearchTodos[0].Score, 0.0)
+		assert.Equal(t, "<mark>牛乳</mark>を買う", res.Data.SearchTodos[0].Snippet)
+	}
+}
+
+func TestLogoutUserMutation(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 210, "Logout", "logout@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{210}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{210}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "logout@example.com", "password")
+
+	body := postGraphQL(t, client, url, `mutation { logoutUser { success message } }`, nil)
+
+	var res struct {
+		Data struct {
+			LogoutUser model.LogoutUserResponse `json:"logoutUser"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, res.Data.LogoutUser.Success)
+
+	// ログアウト後は認証が必要な操作ができない
+	body = postGraphQL(t, client, url, `{ todos { id } }`, nil)
+
+	var todosRes struct {
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &todosRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotEmpty(t, todosRes.Errors) {
+		assert.Equal(t, "認証が必要です", todosRes.Errors[0].Message)
+	}
+}
+
+func TestMeQuery(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 211, "Me", "me@example.com", "password")
+
+	defer func() {
+		gormDB.Where("id IN ?", []uint{211}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+
+	type meResponse struct {
+		Data struct {
+			Me *model.User `json:"me"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+
+	// 未ログインではnull
+	var anonymousRes meResponse
+	body := postGraphQL(t, client, url, `{ me { id name } }`, nil)
+	if err := json.Unmarshal(body, &anonymousRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, anonymousRes.Errors)
+	assert.Nil(t, anonymousRes.Data.Me)
+
+	// ログイン後は自分の情報を返す
+	loginAs(t, client, url, "me@example.com", "password")
+
+	var res meResponse
+	body = postGraphQL(t, client, url, `{ me { id name } }`, nil)
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, res.Errors)
+	if assert.NotNil(t, res.Data.Me) {
+		assert.Equal(t, "211", res.Data.Me.ID)
+		assert.Equal(t, "Me", res.Data.Me.Name)
+	}
+}
+
+func TestDatabaseSessionStore(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 212, "Session", "session@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{212}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{212}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "session@example.com", "password")
+
+	// ログインするとユーザーに紐づくセッションが保存される
+	var count int64
+	if err := gormDB.Model(&database.Session{}).Where("user_id = ?", 212).Count(&count).Error; err != nil {
+		t.Fatalf("セッションカウント取得に失敗: %v", err)
+	}
+	assert.Equal(t, int64(1), count)
+
+	type meResponse struct {
+		Data struct {
+			Me *model.User `json:"me"`
+		} `json:"data"`
+	}
+
+	var res meResponse
+	if err := json.Unmarshal(postGraphQL(t, client, url, `{ me { id } }`, nil), &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.NotNil(t, res.Data.Me)
+
+	// 期限切れのセッションは削除される
+	if err := gormDB.Model(&database.Session{}).Where("user_id = ?", 212).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("セッションの更新に失敗: %v", err)
+	}
+	if err := sessionStore.DeleteExpired(); err != nil {
+		t.Fatalf("期限切れセッションの削除に失敗: %v", err)
+	}
+	if err := gormDB.Model(&database.Session{}).Where("user_id = ?", 212).Count(&count).Error; err != nil {
+		t.Fatalf("セッションカウント取得に失敗: %v", err)
+	}
+	assert.Equal(t, int64(0), count)
+
+	// 行が削除されたセッションのCookieでは認証されない
+	var revokedRes meResponse
+	if err := json.Unmarshal(postGraphQL(t, client, url, `{ me { id } }`, nil), &revokedRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Nil(t, revokedRes.Data.Me)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 213, "Devices", "devices@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{213}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{213}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+
+	// 2台の端末からログイン
+	laptop := newCookieClient(t)
+	loginAs(t, laptop, url, "devices@example.com", "password")
+	phone := newCookieClient(t)
+	loginAs(t, phone, url, "devices@example.com", "password")
+
+	var sessionsRes struct {
+		Data struct {
+			MySessions []model.ActiveSession `json:"mySessions"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	body := postGraphQL(t, laptop, url, `{ mySessions { id ipAddress userAgent current lastSeenAt } }`, nil)
+	if err := json.Unmarshal(body, &sessionsRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, sessionsRes.Errors)
+	if !assert.Len(t, sessionsRes.Data.MySessions, 2) {
+		return
+	}
+
+	var phoneSessionID string
+	for _, activeSession := range sessionsRes.Data.MySessions {
+		assert.Equal(t, "127.0.0.1", activeSession.IPAddress)
+		assert.NotEmpty(t, activeSession.UserAgent)
+		if !activeSession.Current {
+			phoneSessionID = activeSession.ID
+		}
+	}
+	assert.NotEmpty(t, phoneSessionID)
+
+	type meResponse struct {
+		Data struct {
+			Me *model.User `json:"me"`
+		} `json:"data"`
+	}
+
+	// 別端末のセッションを無効化すると即座にログアウト状態になる
+	body = postGraphQL(t, laptop, url, `mutation Revoke($id: ID!) { revokeSession(id: $id) }`, map[string]interface{}{"id": phoneSessionID})
+	assert.Contains(t, string(body), `"revokeSession":true`)
+
+	var phoneRes meResponse
+	if err := json.Unmarshal(postGraphQL(t, phone, url, `{ me { id } }`, nil), &phoneRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Nil(t, phoneRes.Data.Me)
+
+	// 他の端末のセッションをまとめて無効化しても自分のセッションは残る
+	tablet := newCookieClient(t)
+	loginAs(t, tablet, url, "devices@example.com", "password")
+
+	body = postGraphQL(t, laptop, url, `mutation { revokeOtherSessions }`, nil)
+	assert.Contains(t, string(body), `"revokeOtherSessions":1`)
+
+	var tabletRes, laptopRes meResponse
+	if err := json.Unmarshal(postGraphQL(t, tablet, url, `{ me { id } }`, nil), &tabletRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Nil(t, tabletRes.Data.Me)
+	if err := json.Unmarshal(postGraphQL(t, laptop, url, `{ me { id } }`, nil), &laptopRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.NotNil(t, laptopRes.Data.Me)
+}
+
+func TestChangePasswordMutation(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 214, "Changer", "changer@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{214}).Delete(&database.RefreshToken{})
+		gormDB.Where("user_id IN ?", []uint{214}).Delete(&database.APIToken{})
+		gormDB.Where("user_id IN ?", []uint{214}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{214}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore, JWTSecret: []byte("test-jwt-secret")})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "changer@example.com", "password")
+	otherDevice := newCookieClient(t)
+	loginAs(t, otherDevice, url, "changer@example.com", "password")
+
+	// 別の端末でリフレッシュトークンを発行しておく
+	var tokenRes struct {
+		Data struct {
+			LoginWithToken model.TokenAuthResponse `json:"loginWithToken"`
+		} `json:"data"`
+	}
+	body := postGraphQL(t, &http.Client{}, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginWithToken(input: $input) { success tokens { refreshToken } }
+		}`, map[string]interface{}{"input": map[string]interface{}{"email": "changer@example.com", "password": "password"}})
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !assert.True(t, tokenRes.Data.LoginWithToken.Success) || tokenRes.Data.LoginWithToken.Tokens == nil {
+		t.FailNow()
+	}
+
+	mutation := `
+		mutation Change($current: String!, $new: String!) {
+			changePassword(currentPassword: $current, newPassword: $new) { success message }
+		}`
+
+	type changeResponse struct {
+		Data struct {
+			ChangePassword model.ChangePasswordResponse `json:"changePassword"`
+		} `json:"data"`
+	}
+
+	// 現在のパスワードを知っていても、APIトークンでは変更できない
+	var apiTokenRes struct {
+		Data struct {
+			CreateAPIToken model.CreateAPITokenResponse `json:"createApiToken"`
+		} `json:"data"`
+	}
+	body = postGraphQL(t, client, url, `
+		mutation { createApiToken(name: "leaked", scopes: [READ, WRITE]) { success token } }`, nil)
+	if err := json.Unmarshal(body, &apiTokenRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !assert.True(t, apiTokenRes.Data.CreateAPIToken.Success) || apiTokenRes.Data.CreateAPIToken.Token == nil {
+		t.FailNow()
+	}
+	body = postGraphQL(t, newBearerClient(*apiTokenRes.Data.CreateAPIToken.Token), url, mutation, map[string]interface{}{"current": "password", "new": "new-password"})
+	assert.Contains(t, string(body), "この操作はAPIトークンでは実行できません")
+
+	// 現在のパスワードが違う場合は変更できない
+	var wrongRes changeResponse
+	body = postGraphQL(t, client, url, mutation, map[string]interface{}{"current": "wrong-password", "new": "new-password"})
+	if err := json.Unmarshal(body, &wrongRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, wrongRes.Data.ChangePassword.Success)
+
+	// 登録時と同じルールで検証する
+	var shortRes changeResponse
+	body = postGraphQL(t, client, url, mutation, map[string]interface{}{"current": "password", "new": "short"})
+	if err := json.Unmarshal(body, &shortRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, shortRes.Data.ChangePassword.Success)
+	assert.Equal(t, "パスワードは6文字以上で入力してください", shortRes.Data.ChangePassword.Message)
+
+	var res changeResponse
+	body = postGraphQL(t, client, url, mutation, map[string]interface{}{"current": "password", "new": "new-password"})
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, res.Data.ChangePassword.Success)
+
+	// 新しいパスワードが保存されている
+	var dbUser database.User
+	if err := gormDB.First(&dbUser, 214).Error; err != nil {
+		t.Fatalf("ユーザー取得に失敗: %v", err)
+	}
+	assert.NoError(t, passwordhash.NewDefault().Verify(dbUser.Password, "new-password"))
+
+	// 他の端末のセッションは無効化され、変更した端末のセッションは残る
+	assert.Contains(t, string(postGraphQL(t, otherDevice, url, `{ me { id } }`, nil)), `"me":null`)
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ me { id } }`, nil)), `"id":"214"`)
+
+	// 他の端末のリフレッシュトークンも失効している
+	assert.Contains(t, string(postGraphQL(t, &http.Client{}, url, `
+		mutation Refresh($token: String!) {
+			refreshToken(refreshToken: $token) { success }
+		}`, map[string]interface{}{"token": tokenRes.Data.LoginWithToken.Tokens.RefreshToken})), `"success":false`)
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 215, "Forgetful", "forgetful@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{215}).Delete(&database.PasswordResetToken{})
+		gormDB.Where("user_id IN ?", []uint{215}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{215}).Delete(&database.User{})
+	}()
+
+	outbox := mailer.NewMemoryOutbox()
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{
+		GORMDB:       gormDB,
+		SessionStore: sessionStore,
+		Mailer:       outbox,
+		BaseURL:      "http://example.com",
+	})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loggedIn := newCookieClient(t)
+	loginAs(t, loggedIn, url, "forgetful@example.com", "password")
+
+	requestMutation := `
+		mutation Request($email: String!) {
+			requestPasswordReset(email: $email) { success message }
+		}`
+
+	type requestResponse struct {
+		Data struct {
+			RequestPasswordReset model.PasswordResetResponse `json:"requestPasswordReset"`
+		} `json:"data"`
+	}
+
+	// 未登録のメールアドレスでも同じ応答を返し、メールは送信しない
+	var unknownRes requestResponse
+	body := postGraphQL(t, client, url, requestMutation, map[string]interface{}{"email": "nobody@example.com"})
+	if err := json.Unmarshal(body, &unknownRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, unknownRes.Data.RequestPasswordReset.Success)
+	assert.Empty(t, outbox.Messages())
+
+	var res requestResponse
+	body = postGraphQL(t, client, url, requestMutation, map[string]interface{}{"email": "forgetful@example.com"})
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, res.Data.RequestPasswordReset.Success)
+	assert.Equal(t, unknownRes.Data.RequestPasswordReset.Message, res.Data.RequestPasswordReset.Message)
+
+	msg, ok := outbox.LastTo("forgetful@example.com")
+	if !ok {
+		t.Fatal("再設定メールが送信されていません")
+	}
+	matches := regexp.MustCompile(`http://example\.com/reset-password\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	if matches == nil {
+		t.Fatalf("メール本文に再設定リンクがありません: %s", msg.Body)
+	}
+	token := matches[1]
+
+	// トークンは平文で保存しない
+	var stored database.PasswordResetToken
+	if err := gormDB.Where("user_id = ?", 215).First(&stored).Error; err != nil {
+		t.Fatalf("トークン取得に失敗: %v", err)
+	}
+	assert.NotEqual(t, token, stored.TokenHash)
+
+	resetMutation := `
+		mutation Reset($token: String!, $password: String!) {
+			resetPassword(token: $token, newPassword: $password) { success message }
+		}`
+
+	type resetResponse struct {
+		Data struct {
+			ResetPassword model.PasswordResetResponse `json:"resetPassword"`
+		} `json:"data"`
+	}
+
+	var invalidRes resetResponse
+	body = postGraphQL(t, client, url, resetMutation, map[string]interface{}{"token": "invalid-token", "password": "new-password"})
+	if err := json.Unmarshal(body, &invalidRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, invalidRes.Data.ResetPassword.Success)
+
+	var resetRes resetResponse
+	body = postGraphQL(t, client, url, resetMutation, map[string]interface{}{"token": token, "password": "new-password"})
+	if err := json.Unmarshal(body, &resetRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, resetRes.Data.ResetPassword.Success)
+
+	// トークンは一度しか使えない
+	var reusedRes resetResponse
+	body = postGraphQL(t, client, url, resetMutation, map[string]interface{}{"token": token, "password": "another-password"})
+	if err := json.Unmarshal(body, &reusedRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, reusedRes.Data.ResetPassword.Success)
+
+	// 再設定前のセッションは無効化され、新しいパスワードでログインできる
+	assert.Contains(t, string(postGraphQL(t, loggedIn, url, `{ me { id } }`, nil)), `"me":null`)
+	loginAs(t, client, url, "forgetful@example.com", "new-password")
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ me { id } }`, nil)), `"id":"215"`)
+}
+
+func TestEmailVerification(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	const email = "unverified@example.com"
+	gormDB.Where("email = ?", email).Delete(&database.User{})
+
+	defer func() {
+		var ids []uint
+		gormDB.Model(&database.User{}).Where("email = ?", email).Pluck("id", &ids)
+		gormDB.Where("user_id IN ?", ids).Delete(&database.EmailVerificationToken{})
+		gormDB.Where("user_id IN ?", ids).Delete(&database.Session{})
+		gormDB.Where("id IN ?", ids).Delete(&database.User{})
+	}()
+
+	outbox := mailer.NewMemoryOutbox()
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{
+		GORMDB:                   gormDB,
+		SessionStore:             sessionStore,
+		Mailer:                   outbox,
+		BaseURL:                  "http://example.com",
+		RequireEmailVerification: true,
+	})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+
+	registerMutation := `
+		mutation Register($input: RegisterUserInput!) {
+			registerUser(input: $input) { success message user { emailVerified } }
+		}`
+
+	type registerResponse struct {
+		Data struct {
+			RegisterUser model.RegisterUserResponse `json:"registerUser"`
+		} `json:"data"`
+	}
+
+	// メールアドレスの形式でない値は登録できない
+	var invalidRes registerResponse
+	body := postGraphQL(t, client, url, registerMutation, map[string]interface{}{
+		"input": map[string]interface{}{"name": "Unverified", "email": "not-an-email", "password": "password"},
+	})
+	if err := json.Unmarshal(body, &invalidRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, invalidRes.Data.RegisterUser.Success)
+	assert.Equal(t, "有効なメールアドレスを入力してください", invalidRes.Data.RegisterUser.Message)
+
+	var res registerResponse
+	body = postGraphQL(t, client, url, registerMutation, map[string]interface{}{
+		"input": map[string]interface{}{"name": "Unverified", "email": email, "password": "password"},
+	})
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, res.Data.RegisterUser.Success)
+	if assert.NotNil(t, res.Data.RegisterUser.User) {
+		assert.False(t, res.Data.RegisterUser.User.EmailVerified)
+	}
+
+	msg, ok := outbox.LastTo(email)
+	if !ok {
+		t.Fatal("確認メールが送信されていません")
+	}
+	matches := regexp.MustCompile(`http://example\.com/verify\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	if matches == nil {
+		t.Fatalf("メール本文に確認リンクがありません: %s", msg.Body)
+	}
+	token := matches[1]
+
+	loginMutation := `
+		mutation Login($input: LoginUserInput!) {
+			loginUser(input: $input) { success message }
+		}`
+	loginVariables := map[string]interface{}{
+		"input": map[string]interface{}{"email": email, "password": "password"},
+	}
+
+	// 確認前はログインできない
+	assert.Contains(t, string(postGraphQL(t, client, url, loginMutation, loginVariables)), `"success":false`)
+
+	verifyMutation := `
+		mutation Verify($token: String!) {
+			verifyEmail(token: $token) { success message }
+		}`
+
+	type verifyResponse struct {
+		Data struct {
+			VerifyEmail model.VerifyEmailResponse `json:"verifyEmail"`
+		} `json:"data"`
+	}
+
+	var verifyRes verifyResponse
+	body = postGraphQL(t, client, url, verifyMutation, map[string]interface{}{"token": token})
+	if err := json.Unmarshal(body, &verifyRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, verifyRes.Data.VerifyEmail.Success)
+
+	// トークンは一度しか使えない
+	var reusedRes verifyResponse
+	body = postGraphQL(t, client, url, verifyMutation, map[string]interface{}{"token": token})
+	if err := json.Unmarshal(body, &reusedRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, reusedRes.Data.VerifyEmail.Success)
+
+	// 確認後はログインでき、確認済みとして返る
+	loginAs(t, client, url, email, "password")
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ me { emailVerified } }`, nil)), `"emailVerified":true`)
+
+	// 確認済みのユーザーには再送信しない
+	sent := len(outbox.Messages())
+	assert.Contains(t, string(postGraphQL(t, client, url, `mutation { resendVerificationEmail(email: "`+email+`") { success } }`, nil)), `"success":true`)
+	assert.Len(t, outbox.Messages(), sent)
+}
+
+func TestAPITokenBearerAuth(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 216, "Scripter", "scripter@example.com", "password")
+	gormDB.Create(&database.Todo{ID: 241, Text: "Token Todo", UserID: 216})
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{216}).Delete(&database.APIToken{})
+		gormDB.Where("user_id IN ?", []uint{216}).Delete(&database.Todo{})
+		gormDB.Where("user_id IN ?", []uint{216}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{216}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "scripter@example.com", "password")
+
+	createMutation := `
+		mutation Create($name: String!, $scopes: [ApiTokenScope!]!) {
+			createApiToken(name: $name, scopes: $scopes) { success message token apiToken { id scopes } }
+		}`
+
+	type createResponse struct {
+		Data struct {
+			CreateAPIToken model.CreateAPITokenResponse `json:"createApiToken"`
+		} `json:"data"`
+	}
+
+	createToken := func(name string, scopes ...string) (string, string) {
+		t.Helper()
+		var res createResponse
+		body := postGraphQL(t, client, url, createMutation, map[string]interface{}{"name": name, "scopes": scopes})
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		if !res.Data.CreateAPIToken.Success || res.Data.CreateAPIToken.Token == nil {
+			t.Fatalf("APIトークンの作成に失敗: %s", res.Data.CreateAPIToken.Message)
+		}
+		return *res.Data.CreateAPIToken.Token, res.Data.CreateAPIToken.APIToken.ID
+	}
+
+	readToken, _ := createToken("ci-read", "READ")
+	writeToken, writeTokenID := createToken("ci-write", "READ", "WRITE")
+
+	// トークンは平文で保存しない
+	var stored database.APIToken
+	if err := gormDB.Where("user_id = ? AND name = ?", 216, "ci-read").First(&stored).Error; err != nil {
+		t.Fatalf("APIトークン取得に失敗: %v", err)
+	}
+	assert.Equal(t, "READ", stored.Scopes)
+	assert.NotContains(t, stored.TokenHash, readToken)
+
+	// Bearerトークンでもセッションと同じようにユーザーのデータを取得できる
+	readClient := newBearerClient(readToken)
+	assert.Contains(t, string(postGraphQL(t, readClient, url, `{ me { id } }`, nil)), `"id":"216"`)
+	assert.Contains(t, string(postGraphQL(t, readClient, url, `{ todos { text } }`, nil)), `"text":"Token Todo"`)
+
+	// READスコープのみのトークンでは変更できない
+	createTodo := `mutation { createTodo(input: { text: "From CI" }) { text } }`
+	assert.Contains(t, string(postGraphQL(t, readClient, url, createTodo, nil)), "このAPIトークンにはWRITEスコープがありません")
+
+	writeClient := newBearerClient(writeToken)
+	assert.Contains(t, string(postGraphQL(t, writeClient, url, createTodo, nil)), `"text":"From CI"`)
+
+	// トークンでは新しいトークンを発行できない
+	body := postGraphQL(t, writeClient, url, createMutation, map[string]interface{}{"name": "escalate", "scopes": []string{"READ"}})
+	assert.Contains(t, string(body), "この操作はAPIトークンでは実行できません")
+
+	// 無効なトークンは401で拒否する
+	assert.Contains(t, string(postGraphQL(t, newBearerClient("gqt_invalid"), url, `{ me { id } }`, nil)), "APIトークンが無効か有効期限が切れています")
+
+	// 一覧に表示され、失効させると使えなくなる
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ apiTokens { name lastUsedAt } }`, nil)), `"name":"ci-write"`)
+	assert.Contains(t, string(postGraphQL(t, client, url, `mutation Revoke($id: ID!) { revokeApiToken(id: $id) }`, map[string]interface{}{"id": writeTokenID})), `"revokeApiToken":true`)
+	assert.Contains(t, string(postGraphQL(t, writeClient, url, `{ me { id } }`, nil)), "APIトークンが無効か有効期限が切れています")
+}
+
+func TestJWTTokenLoginAndRefresh(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 217, "Mobile", "mobile@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{217}).Delete(&database.RefreshToken{})
+		gormDB.Where("id IN ?", []uint{217}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, JWTSecret: []byte("test-jwt-secret")})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := &http.Client{}
+
+	type tokenResponse struct {
+		Data struct {
+			LoginWithToken model.TokenAuthResponse `json:"loginWithToken"`
+			RefreshToken   model.TokenAuthResponse `json:"refreshToken"`
+		} `json:"data"`
+	}
+
+	var wrongRes tokenResponse
+	body := postGraphQL(t, client, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginWithToken(input: $input) { success message tokens { accessToken refreshToken } }
+		}`, map[string]interface{}{"input": map[string]interface{}{"email": "mobile@example.com", "password": "wrong-password"}})
+	if err := json.Unmarshal(body, &wrongRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, wrongRes.Data.LoginWithToken.Success)
+	assert.Nil(t, wrongRes.Data.LoginWithToken.Tokens)
+
+	var loginRes tokenResponse
+	body = postGraphQL(t, client, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginWithToken(input: $input) { success message tokens { accessToken refreshToken tokenType expiresIn } }
+		}`, map[string]interface{}{"input": map[string]interface{}{"email": "mobile@example.com", "password": "password"}})
+	if err := json.Unmarshal(body, &loginRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !assert.True(t, loginRes.Data.LoginWithToken.Success) || loginRes.Data.LoginWithToken.Tokens == nil {
+		t.FailNow()
+	}
+	tokens := loginRes.Data.LoginWithToken.Tokens
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.Equal(t, int32(900), tokens.ExpiresIn)
+
+	// セッションCookieなしでもアクセストークンで認証できる
+	assert.Contains(t, string(postGraphQL(t, newBearerClient(tokens.AccessToken), url, `{ me { id } }`, nil)), `"id":"217"`)
+
+	// 別の鍵で署名されたトークンは拒否する
+	forgedTS := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, JWTSecret: []byte("other-secret")})
+	defer forgedTS.Close()
+	assert.Contains(t, string(postGraphQL(t, newBearerClient(tokens.AccessToken), forgedTS.URL+`/query`, `{ me { id } }`, nil)), "アクセストークンが無効か有効期限が切れています")
+
+	refreshMutation := `
+		mutation Refresh($token: String!) {
+			refreshToken(refreshToken: $token) { success message tokens { accessToken refreshToken } user { id } }
+		}`
+
+	refresh := func(token string) model.TokenAuthResponse {
+		t.Helper()
+		var res tokenResponse
+		body := postGraphQL(t, client, url, refreshMutation, map[string]interface{}{"token": token})
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		return res.Data.RefreshToken
+	}
+
+	// リフレッシュするたびに新しいトークンに入れ替わる
+	rotated := refresh(tokens.RefreshToken)
+	if !assert.True(t, rotated.Success) || rotated.Tokens == nil {
+		t.FailNow()
+	}
+	assert.NotEqual(t, tokens.RefreshToken, rotated.Tokens.RefreshToken)
+	assert.Equal(t, "217", rotated.User.ID)
+
+	// 使用済みのトークンを再利用すると、同じログインのトークンがすべて失効する
+	reused := refresh(tokens.RefreshToken)
+	assert.False(t, reused.Success)
+	assert.Contains(t, reused.Message, "再利用")
+	assert.False(t, refresh(rotated.Tokens.RefreshToken).Success)
+
+	// 失効させたリフレッシュトークンは使えない
+	var relogin tokenResponse
+	body = postGraphQL(t, client, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginWithToken(input: $input) { success tokens { refreshToken } }
+		}`, map[string]interface{}{"input": map[string]interface{}{"email": "mobile@example.com", "password": "password"}})
+	if err := json.Unmarshal(body, &relogin); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	refreshToken := relogin.Data.LoginWithToken.Tokens.RefreshToken
+	assert.Contains(t, string(postGraphQL(t, client, url, `mutation Revoke($token: String!) { revokeRefreshToken(refreshToken: $token) }`, map[string]interface{}{"token": refreshToken})), `"revokeRefreshToken":true`)
+	assert.False(t, refresh(refreshToken).Success)
+
+	// 無効化されたユーザーには発行せず、同じログインのトークンを失効させる
+	body = postGraphQL(t, client, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginWithToken(input: $input) { success tokens { refreshToken } }
+		}`, map[string]interface{}{"input": map[string]interface{}{"email": "mobile@example.com", "password": "password"}})
+	relogin = tokenResponse{}
+	if err := json.Unmarshal(body, &relogin); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !assert.True(t, relogin.Data.LoginWithToken.Success) || relogin.Data.LoginWithToken.Tokens == nil {
+		t.FailNow()
+	}
+	refreshToken = relogin.Data.LoginWithToken.Tokens.RefreshToken
+	gormDB.Model(&database.User{}).Where("id = ?", 217).Update("disabled_at", time.Now())
+	disabled := refresh(refreshToken)
+	assert.False(t, disabled.Success)
+	assert.Contains(t, disabled.Message, "無効化されています")
+	gormDB.Model(&database.User{}).Where("id = ?", 217).Update("disabled_at", nil)
+	assert.False(t, refresh(refreshToken).Success)
+}
+
+// テスト用のOpenID Connectプロバイダー
+// 認可エンドポイントはユーザーの同意を待たずに認可コードを返す
+type fakeOIDCIssuer struct {
+	*httptest.Server
+
+	clientID string
+	key      *rsa.PrivateKey
+
+	mu sync.Mutex
+	// 次に発行するIDトークンのクレーム
+	claims jwt.MapClaims
+	nonce  string
+}
+
+func newFakeOIDCIssuer(t *testing.T, clientID string) *fakeOIDCIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("RSA鍵の生成に失敗: %v", err)
+	}
+
+	issuer := &fakeOIDCIssuer{clientID: clientID, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != clientID || query.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		issuer.mu.Lock()
+		issuer.nonce = query.Get("nonce")
+		issuer.mu.Unlock()
+
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code=test-code&state="+query.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "test-code" || r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		issuer.mu.Lock()
+		claims := jwt.MapClaims{
+			"iss":   issuer.URL,
+			"aud":   clientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": issuer.nonce,
+		}
+		for k, v := range issuer.claims {
+			claims[k] = v
+		}
+		issuer.mu.Unlock()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+// 次に発行するIDトークンのクレームを設定
+func (f *fakeOIDCIssuer) setClaims(claims jwt.MapClaims) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claims = claims
+}
+
+func TestOIDCLogin(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 218, "Existing", "existing-sso@example.com", "password")
+	gormDB.Where("email = ?", "new-sso@example.com").Delete(&database.User{})
+
+	defer func() {
+		var ids []uint
+		gormDB.Model(&database.User{}).Where("email IN ?", []string{"existing-sso@example.com", "new-sso@example.com"}).Pluck("id", &ids)
+		gormDB.Where("user_id IN ?", ids).Delete(&database.UserIdentity{})
+		gormDB.Where("user_id IN ?", ids).Delete(&database.TwoFactorChallenge{})
+		gormDB.Where("user_id IN ?", ids).Delete(&database.Session{})
+		gormDB.Where("id IN ?", ids).Delete(&database.User{})
+	}()
+
+	issuer := newFakeOIDCIssuer(t, "test-client")
+	defer issuer.Close()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	mux := http.NewServeMux()
+	resolver := &graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore}
+	mux.Handle("/query", newGraphQLHandler(resolver))
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	oidcHandler := handlers.NewOIDCHandler(gormDB, sessionStore, app.URL, []handlers.OIDCProviderConfig{{
+		Name:         "fake",
+		DisplayName:  "Fake",
+		IssuerURL:    issuer.URL,
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+	}})
+	oidcHandler.LoginChecker = resolver
+	mux.HandleFunc("/auth/{provider}/start", oidcHandler.Start)
+	mux.HandleFunc("/auth/{provider}/callback", oidcHandler.Callback)
+
+	// プロバイダーを経由してコールバックまでリダイレクトをたどり、最後のレスポンスを返す
+	login := func(client *http.Client) *http.Response {
+		t.Helper()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if req.URL.Path == "/todos" || req.URL.Path == "/login" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+		resp, err := client.Get(app.URL + "/auth/fake/start")
+		if err != nil {
+			t.Fatalf("ログインリクエストに失敗: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// 未登録のメールアドレスならユーザーを作成し、確認済みにする
+	issuer.setClaims(jwt.MapClaims{"sub": "subject-new", "email": "new-sso@example.com", "email_verified": true, "name": "New SSO"})
+	client := newCookieClient(t)
+	resp := login(client)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	body := string(postGraphQL(t, client, app.URL+"/query", `{ me { name email emailVerified } }`, nil))
+	assert.Contains(t, body, `"name":"New SSO"`)
+	assert.Contains(t, body, `"emailVerified":true`)
+
+	// 同じアカウントで再度ログインしてもユーザーは増えない
+	resp = login(newCookieClient(t))
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	var count int64
+	gormDB.Model(&database.User{}).Where("email = ?", "new-sso@example.com").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// メールアドレスを確認していない既存のユーザーには紐付けない
+	issuer.setClaims(jwt.MapClaims{"sub": "subject-existing", "email": "existing-sso@example.com", "email_verified": true})
+	client = newCookieClient(t)
+	resp = login(client)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(postGraphQL(t, client, app.URL+"/query", `{ me { id } }`, nil)), `"me":null`)
+	var identityCount int64
+	gormDB.Model(&database.UserIdentity{}).Where("user_id = ?", 218).Count(&identityCount)
+	assert.Equal(t, int64(0), identityCount)
+
+	// 確認済みの既存のユーザーとはメールアドレスで紐付ける
+	gormDB.Model(&database.User{}).Where("id = ?", 218).Update("verified_at", time.Now())
+	client = newCookieClient(t)
+	resp = login(client)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Contains(t, string(postGraphQL(t, client, app.URL+"/query", `{ me { id } }`, nil)), `"id":"218"`)
+	var identity database.UserIdentity
+	if err := gormDB.Where("provider = ? AND subject = ?", "fake", "subject-existing").First(&identity).Error; err != nil {
+		t.Fatalf("紐付けが保存されていません: %v", err)
+	}
+	assert.Equal(t, uint(218), identity.UserID)
+
+	// 2段階認証が有効な場合は、確認コードを入力するまでセッションを作らない
+	secret := "JBSWY3DPEHPK3PXP"
+	gormDB.Model(&database.User{}).Where("id = ?", 218).Updates(map[string]interface{}{"two_factor_secret": secret, "two_factor_enabled_at": time.Now()})
+	client = newCookieClient(t)
+	resp = login(client)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("リダイレクト先の取得に失敗: %v", err)
+	}
+	assert.Equal(t, "/login", location.Path)
+	assert.Contains(t, string(postGraphQL(t, client, app.URL+"/query", `{ me { id } }`, nil)), `"me":null`)
+
+	loginToken := strings.TrimPrefix(location.Fragment, "two_factor=")
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("確認コードの生成に失敗: %v", err)
+	}
+	body = string(postGraphQL(t, client, app.URL+"/query", `
+		mutation Verify($token: String!, $code: String!) {
+			verifyTwoFactor(loginToken: $token, code: $code) { success }
+		}`, map[string]interface{}{"token": loginToken, "code": code}))
+	assert.Contains(t, body, `"success":true`)
+	assert.Contains(t, string(postGraphQL(t, client, app.URL+"/query", `{ me { id } }`, nil)), `"id":"218"`)
+
+	// メールアドレスが確認されていないアカウントではログインできない
+	issuer.setClaims(jwt.MapClaims{"sub": "subject-unverified", "email": "existing-sso@example.com", "email_verified": false})
+	client = newCookieClient(t)
+	resp = login(client)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(postGraphQL(t, client, app.URL+"/query", `{ me { id } }`, nil)), `"me":null`)
+
+	// 開始していない認可リクエストのコールバックは拒否する
+	resp, err = newCookieClient(t).Get(app.URL + "/auth/fake/callback?code=test-code&state=forged")
+	if err != nil {
+		t.Fatalf("コールバックリクエストに失敗: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 設定されていないプロバイダーは404
+	resp, err = newCookieClient(t).Get(app.URL + "/auth/unknown/start")
+	if err != nil {
+		t.Fatalf("ログインリクエストに失敗: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTwoFactorAuth(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 219, "Careful", "careful@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{219}).Delete(&database.TwoFactorChallenge{})
+		gormDB.Where("user_id IN ?", []uint{219}).Delete(&database.TwoFactorRecoveryCode{})
+		gormDB.Where("user_id IN ?", []uint{219}).Delete(&database.RefreshToken{})
+		gormDB.Where("user_id IN ?", []uint{219}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{219}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, JWTSecret: []byte("test-jwt-secret")})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "careful@example.com", "password")
+
+	// 有効化を開始し、認証アプリに登録するシークレットを受け取る
+	var enableRes struct {
+		Data struct {
+			EnableTwoFactor model.EnableTwoFactorResponse `json:"enableTwoFactor"`
+		} `json:"data"`
+	}
+	body := postGraphQL(t, client, url, `mutation { enableTwoFactor { success message secret provisioningUri } }`, nil)
+	if err := json.Unmarshal(body, &enableRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !assert.True(t, enableRes.Data.EnableTwoFactor.Success) || enableRes.Data.EnableTwoFactor.Secret == nil {
+		t.FailNow()
+	}
+	secret := *enableRes.Data.EnableTwoFactor.Secret
+	assert.Contains(t, *enableRes.Data.EnableTwoFactor.ProvisioningURI, "otpauth://totp/")
+
+	type twoFactorResponse struct {
+		Data struct {
+			ConfirmTwoFactor model.TwoFactorResponse `json:"confirmTwoFactor"`
+			DisableTwoFactor model.TwoFactorResponse `json:"disableTwoFactor"`
+		} `json:"data"`
+	}
+	confirmMutation := `mutation Confirm($code: String!) { confirmTwoFactor(code: $code) { success message recoveryCodes } }`
+
+	// 確認コードが正しくなければ有効にならない
+	var wrongConfirm twoFactorResponse
+	body = postGraphQL(t, client, url, confirmMutation, map[string]interface{}{"code": "000000"})
+	if err := json.Unmarshal(body, &wrongConfirm); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, wrongConfirm.Data.ConfirmTwoFactor.Success)
+
+	// 現在と次の時間ステップの確認コード（テスト中にステップが進んでも、前後1ステップまでは受け付けられる）
+	step := time.Now().Unix() / 30
+	codeAt := func(offset int64) string {
+		t.Helper()
+		code, err := totp.GenerateCode(secret, time.Unix((step+offset)*30, 0))
+		if err != nil {
+			t.Fatalf("確認コードの生成に失敗: %v", err)
+		}
+		return code
+	}
+
+	code := codeAt(0)
+	var confirmRes twoFactorResponse
+	body = postGraphQL(t, client, url, confirmMutation, map[string]interface{}{"code": code})
+	if err := json.Unmarshal(body, &confirmRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, confirmRes.Data.ConfirmTwoFactor.Success)
+	recoveryCodes := confirmRes.Data.ConfirmTwoFactor.RecoveryCodes
+	assert.Len(t, recoveryCodes, 10)
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ me { twoFactorEnabled } }`, nil)), `"twoFactorEnabled":true`)
+
+	// リカバリーコードは平文で保存しない
+	var storedCodes int64
+	gormDB.Model(&database.TwoFactorRecoveryCode{}).Where("user_id = ? AND code_hash IN ?", 219, recoveryCodes).Count(&storedCodes)
+	assert.Equal(t, int64(0), storedCodes)
+
+	loginMutation := `
+		mutation Login($input: LoginUserInput!) {
+			loginUser(input: $input) { success message twoFactorRequired loginToken }
+		}`
+	verifyMutation := `
+		mutation Verify($loginToken: String!, $code: String!) {
+			verifyTwoFactor(loginToken: $loginToken, code: $code) { success message user { id } }
+		}`
+
+	type loginResponse struct {
+		Data struct {
+			LoginUser       model.LoginUserResponse `json:"loginUser"`
+			VerifyTwoFactor model.LoginUserResponse `json:"verifyTwoFactor"`
+		} `json:"data"`
+	}
+
+	// パスワードが正しくても、確認コードを送るまではログインできない
+	startLogin := func(client *http.Client) string {
+		t.Helper()
+		var res loginResponse
+		body := postGraphQL(t, client, url, loginMutation, map[string]interface{}{
+			"input": map[string]interface{}{"email": "careful@example.com", "password": "password"},
+		})
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		assert.False(t, res.Data.LoginUser.Success)
+		assert.True(t, res.Data.LoginUser.TwoFactorRequired)
+		if res.Data.LoginUser.LoginToken == nil {
+			t.Fatal("loginTokenが返されていません")
+		}
+		assert.Contains(t, string(postGraphQL(t, client, url, `{ me { id } }`, nil)), `"me":null`)
+		return *res.Data.LoginUser.LoginToken
+	}
+	verify := func(client *http.Client, loginToken string, code string) model.LoginUserResponse {
+		t.Helper()
+		var res loginResponse
+		body := postGraphQL(t, client, url, verifyMutation, map[string]interface{}{"loginToken": loginToken, "code": code})
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		return res.Data.VerifyTwoFactor
+	}
+
+	device := newCookieClient(t)
+	loginToken := startLogin(device)
+	assert.False(t, verify(device, loginToken, "000000").Success)
+	// 一度受け付けた確認コードは再利用できない
+	assert.False(t, verify(device, loginToken, code).Success)
+	code = codeAt(1)
+	assert.True(t, verify(device, loginToken, code).Success)
+	assert.Contains(t, string(postGraphQL(t, device, url, `{ me { id } }`, nil)), `"id":"219"`)
+
+	// 完了したloginTokenは再利用できない
+	assert.False(t, verify(newCookieClient(t), loginToken, code).Success)
+
+	// リカバリーコードは一度だけ使える（区切りや大文字小文字は問わない）
+	device = newCookieClient(t)
+	assert.True(t, verify(device, startLogin(device), strings.ToUpper(recoveryCodes[0])).Success)
+	device = newCookieClient(t)
+	assert.False(t, verify(device, startLogin(device), recoveryCodes[0]).Success)
+
+	// 試行回数の上限を超えると、正しいコードでも完了できない
+	device = newCookieClient(t)
+	loginToken = startLogin(device)
+	for i := 0; i < 5; i++ {
+		verify(device, loginToken, "000000")
+	}
+	assert.Contains(t, verify(device, loginToken, code).Message, "有効期限が切れました")
+
+	// トークンでのログインも確認コードが必要
+	var tokenLoginRes struct {
+		Data struct {
+			LoginWithToken           model.TokenAuthResponse `json:"loginWithToken"`
+			VerifyTwoFactorWithToken model.TokenAuthResponse `json:"verifyTwoFactorWithToken"`
+		} `json:"data"`
+	}
+	body = postGraphQL(t, &http.Client{}, url, `
+		mutation Login($input: LoginUserInput!) {
+			loginWithToken(input: $input) { success twoFactorRequired loginToken tokens { accessToken } }
+		}`, map[string]interface{}{"input": map[string]interface{}{"email": "careful@example.com", "password": "password"}})
+	if err := json.Unmarshal(body, &tokenLoginRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, tokenLoginRes.Data.LoginWithToken.TwoFactorRequired)
+	assert.Nil(t, tokenLoginRes.Data.LoginWithToken.Tokens)
+	body = postGraphQL(t, &http.Client{}, url, `
+		mutation Verify($loginToken: String!, $code: String!) {
+			verifyTwoFactorWithToken(loginToken: $loginToken, code: $code) { success tokens { accessToken } }
+		}`, map[string]interface{}{"loginToken": *tokenLoginRes.Data.LoginWithToken.LoginToken, "code": recoveryCodes[1]})
+	if err := json.Unmarshal(body, &tokenLoginRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, tokenLoginRes.Data.VerifyTwoFactorWithToken.Success)
+	assert.NotNil(t, tokenLoginRes.Data.VerifyTwoFactorWithToken.Tokens)
+
+	// 無効化にはパスワードの再入力が必要
+	disableMutation := `mutation Disable($password: String!) { disableTwoFactor(currentPassword: $password) { success message } }`
+	var wrongDisable twoFactorResponse
+	body = postGraphQL(t, client, url, disableMutation, map[string]interface{}{"password": "wrong-password"})
+	if err := json.Unmarshal(body, &wrongDisable); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, wrongDisable.Data.DisableTwoFactor.Success)
+
+	var disableRes twoFactorResponse
+	body = postGraphQL(t, client, url, disableMutation, map[string]interface{}{"password": "password"})
+	if err := json.Unmarshal(body, &disableRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, disableRes.Data.DisableTwoFactor.Success)
+	loginAs(t, newCookieClient(t), url, "careful@example.com", "password")
+}
+
+func TestLoginLockout(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 220, "Locked", "locked@example.com", "password")
+
+	cleanup := func() {
+		gormDB.Where("throttle_key IN ?", []string{"account:locked@example.com", "ip:127.0.0.1"}).Delete(&database.LoginThrottle{})
+		gormDB.Where("email = ?", "locked@example.com").Delete(&database.LoginAttempt{})
+	}
+	cleanup()
+	defer func() {
+		cleanup()
+		gormDB.Where("user_id IN ?", []uint{220}).Delete(&database.AccountUnlockToken{})
+		gormDB.Where("id IN ?", []uint{220}).Delete(&database.User{})
+	}()
+
+	// レプリカ間で共有するデータベースのストアで、3回失敗したらロックする
+	guard := lockout.NewGuard(database.NewLoginThrottleStore(gormDB))
+	guard.AccountPolicy.Threshold = 3
+
+	outbox := mailer.NewMemoryOutbox()
+	ts := newSessionTestServer(t, &graph.Resolver{
+		GORMDB:     gormDB,
+		Mailer:     outbox,
+		BaseURL:    "http://example.com",
+		LoginGuard: guard,
+	})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+
+	login := func(password string) model.LoginUserResponse {
+		t.Helper()
+		body := postGraphQL(t, client, url, `
+			mutation Login($input: LoginUserInput!) {
+				loginUser(input: $input) { success message }
+			}`, map[string]interface{}{
+			"input": map[string]interface{}{"email": "locked@example.com", "password": password},
+		})
+		var res struct {
+			Data struct {
+				LoginUser model.LoginUserResponse `json:"loginUser"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		return res.Data.LoginUser
+	}
+
+	for i := 0; i < 3; i++ {
+		res := login("wrong-password")
+		assert.False(t, res.Success)
+		assert.Equal(t, "メールアドレスまたはパスワードが正しくありません", res.Message)
+	}
+
+	// ロック中は正しいパスワードでもログインできない
+	res := login("password")
+	assert.False(t, res.Success)
+	assert.Contains(t, res.Message, "ログイン試行回数が多すぎます")
+
+	// 失敗は監査記録に残る
+	var wrongPasswords, lockedAttempts int64
+	gormDB.Model(&database.LoginAttempt{}).Where("email = ? AND reason = ? AND user_id = ?", "locked@example.com", "wrong_password", 220).Count(&wrongPasswords)
+	gormDB.Model(&database.LoginAttempt{}).Where("email = ? AND reason = ?", "locked@example.com", "locked").Count(&lockedAttempts)
+	assert.Equal(t, int64(3), wrongPasswords)
+	assert.Equal(t, int64(1), lockedAttempts)
+
+	// ロックの時間は失敗が続くほど長くなる
+	record, err := guard.Store.Get(context.Background(), "account:locked@example.com")
+	if err != nil {
+		t.Fatalf("記録の取得に失敗: %v", err)
+	}
+	assert.Equal(t, 3, record.Failures)
+	next := guard.AccountPolicy.Apply(record, record.LastFailedAt)
+	assert.Equal(t, 2*record.LockedUntil.Sub(record.LastFailedAt), next.LockedUntil.Sub(next.LastFailedAt))
+
+	// ロックした時にロック解除のメールを送信する
+	msg, ok := outbox.LastTo("locked@example.com")
+	if !ok {
+		t.Fatal("ロック解除メールが送信されていません")
+	}
+	matches := regexp.MustCompile(`http://example\.com/unlock\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	if matches == nil {
+		t.Fatalf("メール本文にロック解除リンクがありません: %s", msg.Body)
+	}
+
+	unlockMutation := `
+		mutation Unlock($token: String!) {
+			unlockAccount(token: $token) { success message }
+		}`
+
+	type unlockResponse struct {
+		Data struct {
+			UnlockAccount model.UnlockAccountResponse `json:"unlockAccount"`
+		} `json:"data"`
+	}
+
+	var invalidRes unlockResponse
+	body := postGraphQL(t, client, url, unlockMutation, map[string]interface{}{"token": "invalid-token"})
+	if err := json.Unmarshal(body, &invalidRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, invalidRes.Data.UnlockAccount.Success)
+
+	var unlockRes unlockResponse
+	body = postGraphQL(t, client, url, unlockMutation, map[string]interface{}{"token": matches[1]})
+	if err := json.Unmarshal(body, &unlockRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, unlockRes.Data.UnlockAccount.Success)
+
+	// 同じリンクは二度使えない
+	var reusedRes unlockResponse
+	body = postGraphQL(t, client, url, unlockMutation, map[string]interface{}{"token": matches[1]})
+	if err := json.Unmarshal(body, &reusedRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, reusedRes.Data.UnlockAccount.Success)
+
+	// ロックを解除すればログインできる
+	res = login("password")
+	assert.True(t, res.Success, res.Message)
+}
+
+func TestAuthDirectives(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 221, "Directive", "directive@example.com", "password")
+	createLoginUser(t, gormDB, 222, "Directive Admin", "directive-admin@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{221, 222}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{221, 222}).Delete(&database.User{})
+	}()
+
+	gormDB.Model(&database.User{}).Where("id = ?", 222).Update("role", database.RoleAdmin)
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+
+	type errorsResponse struct {
+		Errors []graphQLError `json:"errors"`
+	}
+
+	// @auth: 未ログインではリゾルバーを実行せずにエラーを返す
+	anonymous := newCookieClient(t)
+	body := postGraphQL(t, anonymous, url, `mutation { createTodo(input: {text: "anonymous"}) { id } }`, nil)
+	var anonymousRes errorsResponse
+	if err := json.Unmarshal(body, &anonymousRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotEmpty(t, anonymousRes.Errors) {
+		assert.Equal(t, "認証が必要です", anonymousRes.Errors[0].Message)
+	}
+	var count int64
+	gormDB.Model(&database.Todo{}).Where("text = ?", "anonymous").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// @hasRole(role: ADMIN): 一般ユーザーは拒否し、運用者は実行できる
+	userClient := newCookieClient(t)
+	loginAs(t, userClient, url, "directive@example.com", "password")
+	body = postGraphQL(t, userClient, url, `{ allTodos { id } }`, nil)
+	var userRes errorsResponse
+	if err := json.Unmarshal(body, &userRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotEmpty(t, userRes.Errors) {
+		assert.Equal(t, "この操作を行う権限がありません", userRes.Errors[0].Message)
+	}
+
+	adminClient := newCookieClient(t)
+	loginAs(t, adminClient, url, "directive-admin@example.com", "password")
+	body = postGraphQL(t, adminClient, url, `{ allTodos { id } }`, nil)
+	var adminRes errorsResponse
+	if err := json.Unmarshal(body, &adminRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Empty(t, adminRes.Errors)
+
+	// ユーザーが削除されたセッションは未ログインとして扱う
+	gormDB.Where("id = ?", 221).Delete(&database.User{})
+	body = postGraphQL(t, userClient, url, `{ todos { id } }`, nil)
+	var deletedRes errorsResponse
+	if err := json.Unmarshal(body, &deletedRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotEmpty(t, deletedRes.Errors) {
+		assert.Equal(t, "認証が必要です", deletedRes.Errors[0].Message)
+	}
+}
+
+func TestAdminUserManagement(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 223, "Admin", "manage-admin@example.com", "password")
+	createLoginUser(t, gormDB, 224, "Target One", "manage-target1@example.com", "password")
+	createLoginUser(t, gormDB, 225, "Target Two", "manage-target2@example.com", "password")
+	gormDB.Model(&database.User{}).Where("id = ?", 223).Update("role", database.RoleAdmin)
+	if err := gormDB.Save(&database.Todo{ID: 242, Text: "target todo", UserID: 225}).Error; err != nil {
+		t.Fatalf("テストTODOの挿入に失敗: %v", err)
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{223, 224, 225}).Delete(&database.Todo{})
+		gormDB.Where("user_id IN ?", []uint{223, 224, 225}).Delete(&database.PasswordResetToken{})
+		gormDB.Where("user_id IN ?", []uint{223, 224, 225}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{223, 224, 225}).Delete(&database.User{})
+	}()
+
+	outbox := mailer.NewMemoryOutbox()
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{
+		GORMDB:       gormDB,
+		SessionStore: sessionStore,
+		Mailer:       outbox,
+		BaseURL:      "http://example.com",
+	})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	admin := newCookieClient(t)
+	loginAs(t, admin, url, "manage-admin@example.com", "password")
+	target := newCookieClient(t)
+	loginAs(t, target, url, "manage-target1@example.com", "password")
+
+	// 検索とページネーション
+	usersQuery := `
+		query Users($first: Int, $after: String, $search: String) {
+			users(first: $first, after: $after, search: $search) {
+				edges { cursor node { id email role disabled } }
+				pageInfo { hasNextPage hasPreviousPage endCursor }
+				totalCount
+			}
+		}`
+
+	type usersResponse struct {
+		Data struct {
+			Users model.UserConnection `json:"users"`
+		} `json:"data"`
+	}
+
+	var firstPage usersResponse
+	body := postGraphQL(t, admin, url, usersQuery, map[string]interface{}{"first": 1, "search": "manage-target"})
+	if err := json.Unmarshal(body, &firstPage); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.Equal(t, int32(2), firstPage.Data.Users.TotalCount)
+	if assert.Len(t, firstPage.Data.Users.Edges, 1) {
+		assert.Equal(t, "224", firstPage.Data.Users.Edges[0].Node.ID)
+		assert.Equal(t, model.RoleUser, firstPage.Data.Users.Edges[0].Node.Role)
+	}
+	assert.True(t, firstPage.Data.Users.PageInfo.HasNextPage)
+
+	var secondPage usersResponse
+	body = postGraphQL(t, admin, url, usersQuery, map[string]interface{}{"first": 1, "search": "manage-target", "after": *firstPage.Data.Users.PageInfo.EndCursor})
+	if err := json.Unmarshal(body, &secondPage); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.Len(t, secondPage.Data.Users.Edges, 1) {
+		assert.Equal(t, "225", secondPage.Data.Users.Edges[0].Node.ID)
+	}
+	assert.False(t, secondPage.Data.Users.PageInfo.HasNextPage)
+	assert.True(t, secondPage.Data.Users.PageInfo.HasPreviousPage)
+
+	adminMutation := func(name string, variables map[string]interface{}) model.AdminUserResponse {
+		t.Helper()
+		args := "(id: $id)"
+		params := "($id: ID!)"
+		if _, ok := variables["role"]; ok {
+			args = "(id: $id, role: $role)"
+			params = "($id: ID!, $role: Role!)"
+		}
+		body := postGraphQL(t, admin, url, `mutation M`+params+` { `+name+args+` { success message user { id role disabled } } }`, variables)
+		var res struct {
+			Data map[string]model.AdminUserResponse `json:"data"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		return res.Data[name]
+	}
+
+	loginResult := func(email string, password string) model.LoginUserResponse {
+		t.Helper()
+		body := postGraphQL(t, newCookieClient(t), url, `
+			mutation Login($input: LoginUserInput!) {
+				loginUser(input: $input) { success message }
+			}`, map[string]interface{}{
+			"input": map[string]interface{}{"email": email, "password": password},
+		})
+		var res struct {
+			Data struct {
+				LoginUser model.LoginUserResponse `json:"loginUser"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		return res.Data.LoginUser
+	}
+
+	// 無効化するとログイン中のセッションも使えなくなり、再ログインもできない
+	disabled := adminMutation("disableUser", map[string]interface{}{"id": "224"})
+	assert.True(t, disabled.Success, disabled.Message)
+	assert.True(t, disabled.User.Disabled)
+
+	body = postGraphQL(t, target, url, `{ todos { id } }`, nil)
+	var todosRes struct {
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &todosRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotEmpty(t, todosRes.Errors) {
+		assert.Equal(t, "認証が必要です", todosRes.Errors[0].Message)
+	}
+
+	res := loginResult("manage-target1@example.com", "password")
+	assert.False(t, res.Success)
+	assert.Contains(t, res.Message, "無効化されています")
+
+	enabled := adminMutation("enableUser", map[string]interface{}{"id": "224"})
+	assert.True(t, enabled.Success, enabled.Message)
+	assert.True(t, loginResult("manage-target1@example.com", "password").Success)
+
+	// パスワードを強制的にリセットすると、元のパスワードではログインできず再設定メールが届く
+	reset := adminMutation("forcePasswordReset", map[string]interface{}{"id": "224"})
+	assert.True(t, reset.Success, reset.Message)
+	assert.False(t, loginResult("manage-target1@example.com", "password").Success)
+	_, ok := outbox.LastTo("manage-target1@example.com")
+	assert.True(t, ok)
+
+	// ロールの変更
+	promoted := adminMutation("setUserRole", map[string]interface{}{"id": "225", "role": "ADMIN"})
+	assert.True(t, promoted.Success, promoted.Message)
+	assert.Equal(t, model.RoleAdmin, promoted.User.Role)
+
+	// 自分自身の降格・無効化・削除はできない
+	self := adminMutation("setUserRole", map[string]interface{}{"id": "223", "role": "USER"})
+	assert.False(t, self.Success)
+	assert.False(t, adminMutation("disableUser", map[string]interface{}{"id": "223"}).Success)
+	assert.False(t, adminMutation("deleteUser", map[string]interface{}{"id": "223"}).Success)
+
+	// 削除するとTODOも削除される
+	deleted := adminMutation("deleteUser", map[string]interface{}{"id": "225"})
+	assert.True(t, deleted.Success, deleted.Message)
+	var count int64
+	gormDB.Model(&database.User{}).Where("id = ?", 225).Count(&count)
+	assert.Equal(t, int64(0), count)
+	gormDB.Model(&database.Todo{}).Where("id = ?", 242).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestUserEmailPrivacy(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 226, "Private", "private@example.com", "password")
+	createLoginUser(t, gormDB, 227, "Privacy Admin", "privacy-admin@example.com", "password")
+	gormDB.Model(&database.User{}).Where("id = ?", 227).Update("role", database.RoleAdmin)
+	if err := gormDB.Save(&database.Todo{ID: 243, Text: "private todo", UserID: 226}).Error; err != nil {
+		t.Fatalf("テストTODOの挿入に失敗: %v", err)
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{226, 227}).Delete(&database.Todo{})
+		gormDB.Where("id IN ?", []uint{226, 227}).Delete(&database.User{})
+	}()
+
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB})
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+
+	type todosResponse struct {
+		Data struct {
+			Todos    []model.Todo `json:"todos"`
+			AllTodos []model.Todo `json:"allTodos"`
+		} `json:"data"`
+	}
+
+	// 本人には自分のメールアドレスを返す
+	owner := newCookieClient(t)
+	loginAs(t, owner, url, "private@example.com", "password")
+	var ownerRes todosResponse
+	body := postGraphQL(t, owner, url, `{ todos { id user { id email } } }`, nil)
+	if err := json.Unmarshal(body, &ownerRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.Len(t, ownerRes.Data.Todos, 1) {
+		assert.Equal(t, "private@example.com", ownerRes.Data.Todos[0].User.Email)
+	}
+
+	// 管理者には他のユーザーのメールアドレスも返す
+	admin := newCookieClient(t)
+	loginAs(t, admin, url, "privacy-admin@example.com", "password")
+	var adminRes todosResponse
+	body = postGraphQL(t, admin, url, `{ allTodos { id user { id email } } }`, nil)
+	if err := json.Unmarshal(body, &adminRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	for _, todo := range adminRes.Data.AllTodos {
+		if todo.ID == "243" {
+			assert.Equal(t, "private@example.com", todo.User.Email)
+		}
+	}
+
+	// ログイン直後のレスポンスでは、まだ認証情報がなくても本人のメールアドレスを返す
+	body = postGraphQL(t, newCookieClient(t), url, `
+		mutation Login($input: LoginUserInput!) {
+			loginUser(input: $input) { success user { email } }
+		}`, map[string]interface{}{
+		"input": map[string]interface{}{"email": "private@example.com", "password": "password"},
+	})
+	var loginRes struct {
+		Data struct {
+			LoginUser model.LoginUserResponse `json:"loginUser"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &loginRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if assert.NotNil(t, loginRes.Data.LoginUser.User) {
+		assert.Equal(t, "private@example.com", loginRes.Data.LoginUser.User.Email)
+	}
+}
+
+func TestDataExportAndAccountDeletion(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 228, "Leaving", "leaving@example.com", "password")
+	for _, testTodo := range []database.Todo{
+		{ID: 244, Text: "first todo", UserID: 228},
+		{ID: 245, Text: "second todo", UserID: 228, Done: true},
+	} {
+		if err := gormDB.Save(&testTodo).Error; err != nil {
+			t.Fatalf("テストTODOの挿入に失敗: %v", err)
+		}
+	}
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{228}).Delete(&database.AuditLog{})
+		gormDB.Where("user_id IN ?", []uint{228}).Delete(&database.DataExportToken{})
+		gormDB.Where("user_id IN ?", []uint{228}).Delete(&database.Todo{})
+		gormDB.Where("user_id IN ?", []uint{228}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{228}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	resolver := &graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore}
+	mux := http.NewServeMux()
+	mux.Handle("/query", newGraphQLHandler(resolver))
+	mux.HandleFunc("/account/export", handlers.NewExportHandler(gormDB, clientip.Resolver{}).Download)
+	app := httptest.NewServer(mux)
+	defer app.Close()
+	resolver.BaseURL = app.URL
+
+	url := app.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "leaving@example.com", "password")
+
+	// エクスポートのリンクを発行してダウンロードする
+	body := postGraphQL(t, client, url, `mutation { exportMyData { success message downloadUrl expiresAt } }`, nil)
+	var exportRes struct {
+		Data struct {
+			ExportMyData model.DataExportResponse `json:"exportMyData"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &exportRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	if !exportRes.Data.ExportMyData.Success || exportRes.Data.ExportMyData.DownloadURL == nil {
+		t.Fatalf("エクスポートに失敗: %s", exportRes.Data.ExportMyData.Message)
+	}
+	downloadURL := *exportRes.Data.ExportMyData.DownloadURL
+
+	// リンクだけでダウンロードできる（Cookieは不要）
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		t.Fatalf("ダウンロードに失敗: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	var archive handlers.DataExport
+	if err := json.NewDecoder(resp.Body).Decode(&archive); err != nil {
+		t.Fatalf("アーカイブのデコードに失敗: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, "leaving@example.com", archive.Profile.Email)
+	if assert.Len(t, archive.Todos, 2) {
+		assert.Equal(t, "first todo", archive.Todos[0].Text)
+		assert.True(t, archive.Todos[1].Done)
+	}
+
+	// リンクは一度だけ使える
+	resp, err = http.Get(downloadURL)
+	if err != nil {
+		t.Fatalf("ダウンロードに失敗: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	issueExportLink := func() string {
+		t.Helper()
+		var res struct {
+			Data struct {
+				ExportMyData model.DataExportResponse `json:"exportMyData"`
+			} `json:"data"`
+		}
+		body := postGraphQL(t, client, url, `mutation { exportMyData { success message downloadUrl } }`, nil)
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		if !res.Data.ExportMyData.Success || res.Data.ExportMyData.DownloadURL == nil {
+			t.Fatalf("エクスポートに失敗: %s", res.Data.ExportMyData.Message)
+		}
+		return *res.Data.ExportMyData.DownloadURL
+	}
+	download := func(link string) int {
+		t.Helper()
+		resp, err := http.Get(link)
+		if err != nil {
+			t.Fatalf("ダウンロードに失敗: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// 発行後に管理者に無効化された場合はダウンロードできない
+	disabledLink := issueExportLink()
+	gormDB.Model(&database.User{}).Where("id = ?", 228).Update("disabled_at", time.Now())
+	assert.Equal(t, http.StatusForbidden, download(disabledLink))
+	gormDB.Model(&database.User{}).Where("id = ?", 228).Update("disabled_at", nil)
+
+	// 発行後にアカウントを削除した場合は無効なリンクとして扱う
+	deletedLink := issueExportLink()
+
+	deleteMutation := `
+		mutation Delete($password: String!) {
+			deleteMyAccount(password: $password) { success message }
+		}`
+	type deleteResponse struct {
+		Data struct {
+			DeleteMyAccount model.DeleteAccountResponse `json:"deleteMyAccount"`
+		} `json:"data"`
+	}
+
+	// パスワードが違う場合は削除しない
+	var wrongRes deleteResponse
+	body = postGraphQL(t, client, url, deleteMutation, map[string]interface{}{"password": "wrong-password"})
+	if err := json.Unmarshal(body, &wrongRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, wrongRes.Data.DeleteMyAccount.Success)
+
+	var deleteRes deleteResponse
+	body = postGraphQL(t, client, url, deleteMutation, map[string]interface{}{"password": "password"})
+	if err := json.Unmarshal(body, &deleteRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, deleteRes.Data.DeleteMyAccount.Success, deleteRes.Data.DeleteMyAccount.Message)
+
+	// ユーザー・TODO・セッションが削除され、監査記録は残る
+	var count int64
+	gormDB.Model(&database.User{}).Where("id = ?", 228).Count(&count)
+	assert.Equal(t, int64(0), count)
+	gormDB.Model(&database.Todo{}).Where("user_id = ?", 228).Count(&count)
+	assert.Equal(t, int64(0), count)
+	gormDB.Model(&database.Session{}).Where("user_id = ?", 228).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	var actions []string
+	gormDB.Model(&database.AuditLog{}).Where("user_id = ?", 228).Order("id").Pluck("action", &actions)
+	assert.Equal(t, []string{
+		database.AuditActionDataExportRequested,
+		database.AuditActionDataExportDownloaded,
+		database.AuditActionDataExportRequested,
+		database.AuditActionDataExportRequested,
+		database.AuditActionAccountDeleted,
+	}, actions)
+
+	body = postGraphQL(t, client, url, `{ me { id } }`, nil)
+	assert.JSONEq(t, `{"data":{"me":null}}`, string(body))
+
+	assert.Equal(t, http.StatusNotFound, download(deletedLink))
+}
+
+func TestProfileUpdateAndEmailChange(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 229, "Mover", "mover-old@example.com", "password")
+	createLoginUser(t, gormDB, 230, "Squatter", "squatter@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{229, 230}).Delete(&database.EmailChangeToken{})
+		gormDB.Where("user_id IN ?", []uint{229, 230}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{229, 230}).Delete(&database.User{})
+	}()
+
+	outbox := mailer.NewMemoryOutbox()
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	resolver := &graph.Resolver{
+		GORMDB:       gormDB,
+		SessionStore: sessionStore,
+		Mailer:       outbox,
+		BaseURL:      "http://example.com",
+	}
+	ts := httptest.NewServer(newGraphQLHandler(resolver))
+	defer ts.Close()
+
+	url := ts.URL + `/query`
+	client := newCookieClient(t)
+	loginAs(t, client, url, "mover-old@example.com", "password")
+
+	// 名前を変更する
+	body := postGraphQL(t, client, url, `mutation { updateProfile(name: "  Renamed  ") { success message user { name } } }`, nil)
+	var profileRes struct {
+		Data struct {
+			UpdateProfile model.UpdateProfileResponse `json:"updateProfile"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &profileRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, profileRes.Data.UpdateProfile.Success, profileRes.Data.UpdateProfile.Message)
+	if assert.NotNil(t, profileRes.Data.UpdateProfile.User) {
+		assert.Equal(t, "Renamed", profileRes.Data.UpdateProfile.User.Name)
+	}
+
+	body = postGraphQL(t, client, url, `mutation { updateProfile(name: " ") { success } }`, nil)
+	assert.JSONEq(t, `{"data":{"updateProfile":{"success":false}}}`, string(body))
+
+	requestMutation := `
+		mutation Request($newEmail: String!) {
+			requestEmailChange(newEmail: $newEmail) { success message fieldErrors { field code } }
+		}`
+	type requestResponse struct {
+		Data struct {
+			RequestEmailChange model.EmailChangeResponse `json:"requestEmailChange"`
+		} `json:"data"`
+	}
+
+	// 他のユーザーが使っているメールアドレスには確認メールを送らない
+	var takenRes requestResponse
+	body = postGraphQL(t, client, url, requestMutation, map[string]interface{}{"newEmail": "squatter@example.com"})
+	if err := json.Unmarshal(body, &takenRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.False(t, takenRes.Data.RequestEmailChange.Success)
+	assert.Equal(t, "このメールアドレスは既に登録されています", takenRes.Data.RequestEmailChange.Message)
+	if assert.Len(t, takenRes.Data.RequestEmailChange.FieldErrors, 1) {
+		assert.Equal(t, "newEmail", takenRes.Data.RequestEmailChange.FieldErrors[0].Field)
+		assert.Equal(t, model.FieldErrorCodeAlreadyRegistered, takenRes.Data.RequestEmailChange.FieldErrors[0].Code)
+	}
+	assert.Empty(t, outbox.Messages())
+
+	var requestRes requestResponse
+	body = postGraphQL(t, client, url, requestMutation, map[string]interface{}{"newEmail": "mover-new@example.com"})
+	if err := json.Unmarshal(body, &requestRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	assert.True(t, requestRes.Data.RequestEmailChange.Success, requestRes.Data.RequestEmailChange.Message)
+
+	// 確定するまではメールアドレスは変わらない
+	var dbUser database.User
+	gormDB.First(&dbUser, 229)
+	assert.Equal(t, "mover-old@example.com", dbUser.Email)
+
+	msg, ok := outbox.LastTo("mover-new@example.com")
+	if !ok {
+		t.Fatalf("変更確認メールが送信されていません")
+	}
+	matches := regexp.MustCompile(`http://example\.com/confirm-email\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	if len(matches) != 2 {
+		t.Fatalf("メール本文に確認リンクが含まれていません: %s", msg.Body)
+	}
+
+	// リンクはログインしていないブラウザで開かれることもある
+	confirmMutation := `
+		mutation Confirm($token: String!) {
+			confirmEmailChange(token: $token) { success message }
+		}`
+	body = postGraphQL(t, newCookieClient(t), url, confirmMutation, map[string]interface{}{"token": matches[1]})
+	assert.JSONEq(t, `{"data":{"confirmEmailChange":{"success":true,"message":"メールアドレスを変更しました"}}}`, string(body))
+
+	gormDB.First(&dbUser, 229)
+	assert.Equal(t, "mover-new@example.com", dbUser.Email)
+	assert.NotNil(t, dbUser.VerifiedAt)
+
+	// 変更前のメールアドレスに通知が届く
+	notice, ok := outbox.LastTo("mover-old@example.com")
+	if assert.True(t, ok, "変更通知が送信されていません") {
+		assert.Contains(t, notice.Body, "mover-new@example.com")
+	}
+
+	// 既存のセッションに保存しているメールアドレスも更新される
+	var rows []database.Session
+	gormDB.Where("user_id = ?", 229).Find(&rows)
+	if assert.NotEmpty(t, rows) {
+		for _, row := range rows {
+			values := map[interface{}]interface{}{}
+			if err := securecookie.DecodeMulti("session", row.Data, &values, sessionStore.Codecs...); err != nil {
+				t.Fatalf("セッションのデコードに失敗: %v", err)
+			}
+			assert.Equal(t, "mover-new@example.com", values["email"])
+		}
+	}
+	body = postGraphQL(t, client, url, `{ me { email } }`, nil)
+	assert.JSONEq(t, `{"data":{"me":{"email":"mover-new@example.com"}}}`, string(body))
+
+	// リンクは一度だけ使える
+	body = postGraphQL(t, newCookieClient(t), url, confirmMutation, map[string]interface{}{"token": matches[1]})
+	assert.Contains(t, string(body), `"success":false`)
+
+	// 新しいメールアドレスでログインできる
+	loginAs(t, newCookieClient(t), url, "mover-new@example.com", "password")
+}
+
+func TestPasswordRehashOnLogin(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	// 既存のユーザーと同じ bcrypt のハッシュで作成する
+	createLoginUser(t, gormDB, 231, "Legacy", "legacy-hash@example.com", "password")
+	longPassphrase := strings.Repeat("correct horse battery staple ", 3)
+
+	defer func() {
+		gormDB.Where("email IN ?", []string{"legacy-hash@example.com", "long-passphrase@example.com"}).Delete(&database.User{})
+	}()
+
+	ts := httptest.NewServer(newGraphQLHandler(&graph.Resolver{GORMDB: gormDB, Mailer: mailer.NewMemoryOutbox()}))
+	defer ts.Close()
+	url := ts.URL + `/query`
+
+	storedPassword := func(email string) string {
+		var dbUser database.User
+		if err := gormDB.Where("email = ?", email).First(&dbUser).Error; err != nil {
+			t.Fatalf("ユーザーの取得に失敗: %v", err)
+		}
+		return dbUser.Password
+	}
+
+	// パスワードを間違えた場合はハッシュを作り直さない
+	body := postGraphQL(t, newCookieClient(t), url, `
+		mutation { loginUser(input: {email: "legacy-hash@example.com", password: "wrong-password"}) { success } }`, nil)
+	assert.JSONEq(t, `{"data":{"loginUser":{"success":false}}}`, string(body))
+	assert.True(t, strings.HasPrefix(storedPassword("legacy-hash@example.com"), "$2a$"))
+
+	// ログインに成功すると argon2id のハッシュに置き換わる
+	loginAs(t, newCookieClient(t), url, "legacy-hash@example.com", "password")
+	rehashed := storedPassword("legacy-hash@example.com")
+	assert.True(t, strings.HasPrefix(rehashed, "$argon2id$v=19$m=19456,t=2,p=1$"), rehashed)
+	loginAs(t, newCookieClient(t), url, "legacy-hash@example.com", "password")
+	assert.Equal(t, rehashed, storedPassword("legacy-hash@example.com"))
+
+	// 古いパラメータの argon2id のハッシュも作り直す
+	weak := &passwordhash.Argon2id{Memory: 8 * 1024, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+	weakHash, err := weak.Hash("password")
+	if err != nil {
+		t.Fatalf("パスワードのハッシュ化に失敗: %v", err)
+	}
+	gormDB.Model(&database.User{}).Where("id = ?", 231).Update("password", weakHash)
+	loginAs(t, newCookieClient(t), url, "legacy-hash@example.com", "password")
+	assert.True(t, strings.HasPrefix(storedPassword("legacy-hash@example.com"), "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	// 72バイトを超えるパスフレーズも末尾まで区別する
+	body = postGraphQL(t, newCookieClient(t), url, `
+		mutation Register($input: RegisterUserInput!) {
+			registerUser(input: $input) { success message }
+		}`, map[string]interface{}{
+		"input": map[string]interface{}{"name": "Long", "email": "long-passphrase@example.com", "password": longPassphrase},
+	})
+	assert.Contains(t, string(body), `"success":true`)
+
+	body = postGraphQL(t, newCookieClient(t), url, `
+		mutation Login($input: LoginUserInput!) { loginUser(input: $input) { success } }`, map[string]interface{}{
+		"input": map[string]interface{}{"email": "long-passphrase@example.com", "password": longPassphrase[:80] + "x"},
+	})
+	assert.JSONEq(t, `{"data":{"loginUser":{"success":false}}}`, string(body))
+	loginAs(t, newCookieClient(t), url, "long-passphrase@example.com", longPassphrase)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 232, "Policy Holder", "policy-holder@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{232}).Delete(&database.Session{})
+		gormDB.Where("email IN ?", []string{"policy-holder@example.com", "policy-new@example.com"}).Delete(&database.User{})
+	}()
+
+	// 漏洩したパスワードの一覧には SHA-1 ハッシュのみを書く
+	breachedSum := sha1.Sum([]byte("Summer2024!"))
+	breachList, err := passwordpolicy.ReadBreachList(strings.NewReader(
+		"# テスト用の一覧\n" + strings.ToUpper(hex.EncodeToString(breachedSum[:])) + ":42\n",
+	))
+	if err != nil {
+		t.Fatalf("漏洩パスワード一覧の読み込みに失敗: %v", err)
+	}
+	assert.Equal(t, 1, breachList.Len())
+
+	policy := passwordpolicy.Policy{
+		MinLength:            10,
+		MaxLength:            64,
+		RequiredClasses:      3,
+		DisallowPersonalInfo: true,
+		Breached:             breachList,
+	}
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, Mailer: mailer.NewMemoryOutbox(), PasswordPolicy: &policy})
+	defer ts.Close()
+	url := ts.URL + `/query`
+
+	registerMutation := `
+		mutation Register($input: RegisterUserInput!) {
+			registerUser(input: $input) { success message fieldErrors { field code message } }
+		}`
+	type registerResponse struct {
+		Data struct {
+			RegisterUser model.RegisterUserResponse `json:"registerUser"`
+		} `json:"data"`
+	}
+	register := func(name string, email string, password string) model.RegisterUserResponse {
+		t.Helper()
+		var res registerResponse
+		body := postGraphQL(t, newCookieClient(t), url, registerMutation, map[string]interface{}{
+			"input": map[string]interface{}{"name": name, "email": email, "password": password},
+		})
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗: %v", err)
+		}
+		return res.Data.RegisterUser
+	}
+	codesOf := func(fieldErrors []*model.FieldError) []model.FieldErrorCode {
+		codes := make([]model.FieldErrorCode, 0, len(fieldErrors))
+		for _, fieldError := range fieldErrors {
+			codes = append(codes, fieldError.Code)
+		}
+		return codes
+	}
+
+	// 未入力のフィールドはそれぞれエラーになる
+	res := register("", "", "")
+	assert.False(t, res.Success)
+	if assert.Len(t, res.FieldErrors, 3) {
+		assert.Equal(t, "name", res.FieldErrors[0].Field)
+		assert.Equal(t, "email", res.FieldErrors[1].Field)
+		assert.Equal(t, "password", res.FieldErrors[2].Field)
+		assert.Equal(t, model.FieldErrorCodeRequired, res.FieldErrors[2].Code)
+	}
+
+	// 違反したルールはすべて返し、message には最初の違反を入れる
+	res = register("Policy New", "not-an-email", "short")
+	assert.False(t, res.Success)
+	assert.Equal(t, "有効なメールアドレスを入力してください", res.Message)
+	assert.Equal(t, []model.FieldErrorCode{
+		model.FieldErrorCodeInvalidFormat,
+		model.FieldErrorCodeTooShort,
+		model.FieldErrorCodeMissingCharacterClasses,
+	}, codesOf(res.FieldErrors))
+	assert.Equal(t, "password", res.FieldErrors[1].Field)
+	assert.Equal(t, "パスワードは10文字以上で入力してください", res.FieldErrors[1].Message)
+
+	res = register("Policy New", "policy-new@example.com", strings.Repeat("Aa1", 30))
+	assert.Equal(t, []model.FieldErrorCode{model.FieldErrorCodeTooLong}, codesOf(res.FieldErrors))
+
+	res = register("Policy New", "policy-new@example.com", "Policy-New-2024")
+	assert.Equal(t, []model.FieldErrorCode{model.FieldErrorCodeContainsPersonalInfo}, codesOf(res.FieldErrors))
+
+	res = register("Policy New", "policy-new@example.com", "Summer2024!")
+	assert.Equal(t, []model.FieldErrorCode{model.FieldErrorCodeBreached}, codesOf(res.FieldErrors))
+
+	// ルールを満たしていれば登録でき、エラーは空になる
+	res = register("Policy New", "policy-new@example.com", "Tr0ub4dor&3-horse")
+	assert.True(t, res.Success, res.Message)
+	assert.Empty(t, res.FieldErrors)
+
+	// 登録済みのメールアドレスは email の検証エラーになる
+	res = register("Policy Again", "policy-new@example.com", "Tr0ub4dor&3-horse")
+	assert.False(t, res.Success)
+	if assert.Len(t, res.FieldErrors, 1) {
+		assert.Equal(t, "email", res.FieldErrors[0].Field)
+		assert.Equal(t, model.FieldErrorCodeAlreadyRegistered, res.FieldErrors[0].Code)
+	}
+
+	// パスワードの変更も同じルールで検証する
+	client := newCookieClient(t)
+	loginAs(t, client, url, "policy-holder@example.com", "password")
+	body := postGraphQL(t, client, url, `
+		mutation Change($current: String!, $new: String!) {
+			changePassword(currentPassword: $current, newPassword: $new) { success fieldErrors { field code } }
+		}`, map[string]interface{}{"current": "password", "new": "policy-holder-2024"})
+	assert.JSONEq(t, `{"data":{"changePassword":{"success":false,"fieldErrors":[
+		{"field":"newPassword","code":"CONTAINS_PERSONAL_INFO"}
+	]}}}`, string(body))
+}
+
+// graphql-transport-ws のメッセージ
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// WebSocketで接続し、connection_init を送る（ack を受け取ったかを返す）
+func dialSubscription(t *testing.T, serverURL string, client *http.Client, initPayload map[string]interface{}) (*websocket.Conn, bool) {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/query"
+	header := http.Header{}
+	header.Set("Origin", serverURL)
+	if client != nil {
+		req, err := http.NewRequest(http.MethodGet, serverURL, nil)
+		if err != nil {
+			t.Fatalf("リクエストの作成に失敗: %v", err)
+		}
+		for _, cookie := range client.Jar.Cookies(req.URL) {
+			header.Add("Cookie", cookie.String())
+		}
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+	conn, _, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("WebSocketの接続に失敗: %v", err)
+	}
+
+	payload, _ := json.Marshal(initPayload)
+	if err := conn.WriteJSON(wsMessage{Type: "connection_init", Payload: payload}); err != nil {
+		t.Fatalf("connection_init の送信に失敗: %v", err)
+	}
+	var ack wsMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&ack); err != nil {
+		return conn, false
+	}
+	return conn, ack.Type == "connection_ack"
+}
+
+func TestTodoChangedSubscription(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 233, "Watcher", "watcher@example.com", "password")
+	createLoginUser(t, gormDB, 234, "Neighbor", "neighbor@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{233, 234}).Delete(&database.Todo{})
+		gormDB.Where("user_id IN ?", []uint{233, 234}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{233, 234}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{
+		GORMDB:       gormDB,
+		SessionStore: sessionStore,
+		Broker:       pubsub.NewMemoryBroker(),
+	})
+	defer ts.Close()
+	url := ts.URL + `/query`
+
+	// 未ログインの接続は受け付けない
+	anonymous, acked := dialSubscription(t, ts.URL, nil, nil)
+	anonymous.Close()
+	assert.False(t, acked)
+
+	// 別のタブ（同じセッションCookie）で購読する
+	tab := newCookieClient(t)
+	loginAs(t, tab, url, "watcher@example.com", "password")
+	conn, acked := dialSubscription(t, ts.URL, tab, nil)
+	defer conn.Close()
+	if !acked {
+		t.Fatalf("connection_ack を受け取れませんでした")
+	}
+	subscribe, _ := json.Marshal(map[string]interface{}{
+		"query": `subscription { todoChanged { type todoId todo { id text done user { id } } } }`,
+	})
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: subscribe}); err != nil {
+		t.Fatalf("subscribe の送信に失敗: %v", err)
+	}
+
+	type todoChangedPayload struct {
+		Data struct {
+			TodoChanged model.TodoChangedEvent `json:"todoChanged"`
+		} `json:"data"`
+	}
+	next := func() model.TodoChangedEvent {
+		t.Helper()
+		for {
+			var msg wsMessage
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("イベントの受信に失敗: %v", err)
+			}
+			if msg.Type == "ping" || msg.Type == "pong" {
+				continue
+			}
+			if msg.Type != "next" {
+				t.Fatalf("想定外のメッセージ: %s %s", msg.Type, msg.Payload)
+			}
+			var payload todoChangedPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				t.Fatalf("イベントのデコードに失敗: %v", err)
+			}
+			return payload.Data.TodoChanged
+		}
+	}
+
+	// 購読はサーバー側で非同期に開始されるため、少し待ってから変更する
+	time.Sleep(100 * time.Millisecond)
+
+	// 他のユーザーの変更は届かない
+	neighbor := newCookieClient(t)
+	loginAs(t, neighbor, url, "neighbor@example.com", "password")
+	postGraphQL(t, neighbor, url, `mutation { createTodo(input: {text: "neighbor todo"}) { id } }`, nil)
+
+	// もう一方のタブでの作成・更新・削除が届く
+	other := newCookieClient(t)
+	loginAs(t, other, url, "watcher@example.com", "password")
+	body := postGraphQL(t, other, url, `mutation { createTodo(input: {text: "shared todo"}) { id } }`, nil)
+	var createRes struct {
+		Data struct {
+			CreateTodo model.Todo `json:"createTodo"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &createRes); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗: %v", err)
+	}
+	todoID := createRes.Data.CreateTodo.ID
+
+	created := next()
+	assert.Equal(t, model.TodoChangeTypeCreated, created.Type)
+	assert.Equal(t, todoID, created.TodoID)
+	if assert.NotNil(t, created.Todo) {
+		assert.Equal(t, "shared todo", created.Todo.Text)
+		assert.Equal(t, "233", created.Todo.User.ID)
+	}
+
+	postGraphQL(t, other, url, `mutation Toggle($id: ID!) { toggleTodo(id: $id) { id } }`, map[string]interface{}{"id": todoID})
+	updated := next()
+	assert.Equal(t, model.TodoChangeTypeUpdated, updated.Type)
+	if assert.NotNil(t, updated.Todo) {
+		assert.True(t, updated.Todo.Done)
+	}
+
+	postGraphQL(t, other, url, `mutation Delete($id: ID!) { deleteTodo(id: $id) }`, map[string]interface{}{"id": todoID})
+	deleted := next()
+	assert.Equal(t, model.TodoChangeTypeDeleted, deleted.Type)
+	assert.Equal(t, todoID, deleted.TodoID)
+	assert.Nil(t, deleted.Todo)
+}
+
+func TestBootstrapAdmins(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 235, "Squatter", "bootstrap-unverified@example.com", "password")
+	createLoginUser(t, gormDB, 236, "Owner", "bootstrap-verified@example.com", "password")
+	gormDB.Model(&database.User{}).Where("id = ?", 236).Update("verified_at", time.Now())
+
+	defer func() {
+		gormDB.Where("id IN ?", []uint{235, 236}).Delete(&database.User{})
+	}()
+
+	var adminCount int64
+	gormDB.Model(&database.User{}).Where("role = ?", database.RoleAdmin).Count(&adminCount)
+	if adminCount > 0 {
+		t.Fatalf("管理者が残っているため検証できません")
+	}
+
+	emails := []string{"bootstrap-unverified@example.com", "bootstrap-verified@example.com"}
+	roleOf := func(id uint) string {
+		var dbUser database.User
+		gormDB.First(&dbUser, id)
+		return dbUser.Role
+	}
+
+	// メールアドレスを確認していないユーザーは管理者にしない
+	promoted, err := database.BootstrapAdmins(gormDB, emails)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), promoted)
+	assert.Equal(t, database.RoleUser, roleOf(235))
+	assert.Equal(t, database.RoleAdmin, roleOf(236))
+
+	// 管理者がいる場合は何もしないため、setUserRole での変更を上書きしない
+	gormDB.Model(&database.User{}).Where("id = ?", 235).Update("verified_at", time.Now())
+	promoted, err = database.BootstrapAdmins(gormDB, emails)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), promoted)
+	assert.Equal(t, database.RoleUser, roleOf(235))
+}
+
+func TestLogoutRoute(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 237, "Leaver", "logout-route@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{237}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{237}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	authHandler, err := handlers.NewAuthHandler(gormDB, "test", templatesFS, sessionStore)
+	if err != nil {
+		t.Fatalf("認証ハンドラーの初期化に失敗: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/query", newGraphQLHandler(&graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore}))
+	mux.HandleFunc("/logout", authHandler.Logout)
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	url := app.URL + `/query`
+	client := newCookieClient(t)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	loginAs(t, client, url, "logout-route@example.com", "password")
+
+	logout := func(method string, origin string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, app.URL+"/logout", nil)
+		if err != nil {
+			t.Fatalf("リクエストの作成に失敗: %v", err)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("ログアウトに失敗: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// GETや他のサイトからのPOSTではログアウトしない
+	assert.Equal(t, http.StatusMethodNotAllowed, logout(http.MethodGet, ""))
+	assert.Equal(t, http.StatusForbidden, logout(http.MethodPost, "https://evil.example.com"))
+	// Origin も Referer もない場合は、送信元を確認できないため受け付けない
+	assert.Equal(t, http.StatusForbidden, logout(http.MethodPost, ""))
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ me { id } }`, nil)), `"id":"237"`)
+
+	assert.Equal(t, http.StatusSeeOther, logout(http.MethodPost, app.URL))
+	assert.JSONEq(t, `{"data":{"me":null}}`, string(postGraphQL(t, client, url, `{ me { id } }`, nil)))
+}
+
+func TestLoginRegeneratesSessionID(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 238, "Fixated", "fixated@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ? OR user_id IS NULL", []uint{238}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{238}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	ts := newSessionTestServer(t, &graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore})
+	defer ts.Close()
+	url := ts.URL + `/query`
+
+	// 攻撃者が用意したログイン前のセッションを被害者のブラウザに仕込む
+	planted := sessions.NewSession(sessionStore, "session")
+	planted.Options = &sessions.Options{Path: "/", MaxAge: 3600}
+	planted.Values["planted"] = true
+	recorder := httptest.NewRecorder()
+	if err := sessionStore.Save(httptest.NewRequest(http.MethodGet, "/", nil), recorder, planted); err != nil {
+		t.Fatalf("セッションの保存に失敗: %v", err)
+	}
+	plantedCookie := recorder.Result().Cookies()[0]
+
+	client := newCookieClient(t)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	client.Jar.SetCookies(req.URL, []*http.Cookie{{Name: plantedCookie.Name, Value: plantedCookie.Value, Path: "/"}})
+	loginAs(t, client, url, "fixated@example.com", "password")
+
+	// ログインすると新しいセッションIDが発行され、仕込まれたセッションは削除される
+	var count int64
+	gormDB.Model(&database.Session{}).Where("id = ?", planted.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	attacker := newCookieClient(t)
+	attacker.Jar.SetCookies(req.URL, []*http.Cookie{{Name: plantedCookie.Name, Value: plantedCookie.Value, Path: "/"}})
+	assert.JSONEq(t, `{"data":{"me":null}}`, string(postGraphQL(t, attacker, url, `{ me { id } }`, nil)))
+	assert.Contains(t, string(postGraphQL(t, client, url, `{ me { id } }`, nil)), `"id":"238"`)
+}
+
+func TestClientIPIgnoresSpoofedForwardedFor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.RemoteAddr = "10.0.0.5:43210"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+
+	// プロキシを信頼しない設定では X-Forwarded-For を使わない
+	assert.Equal(t, "10.0.0.5", clientip.Resolver{}.IP(req))
+
+	// クライアントが書いた先頭ではなく、プロキシが追記した末尾の値を使う
+	assert.Equal(t, "198.51.100.7", clientip.New(1).IP(req))
+
+	// 想定より少ない場合はプロキシを経由していないため接続元のアドレスを使う
+	assert.Equal(t, "10.0.0.5", clientip.New(3).IP(req))
+}
+
+// 常に送信に失敗するメール送信
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	return fmt.Errorf("SMTPサーバーに接続できません")
+}
+
+func TestRequestPasswordResetHidesSendFailure(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 239, "Unlucky", "unlucky@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{239}).Delete(&database.PasswordResetToken{})
+		gormDB.Where("id IN ?", []uint{239}).Delete(&database.User{})
+	}()
+
+	ts := httptest.NewServer(newGraphQLHandler(&graph.Resolver{GORMDB: gormDB, Mailer: failingMailer{}}))
+	defer ts.Close()
+	url := ts.URL + `/query`
+
+	mutation := `
+		mutation Request($email: String!) {
+			requestPasswordReset(email: $email) { success message }
+		}`
+
+	// 送信に失敗しても、登録されていないメールアドレスと同じレスポンスを返す
+	registered := postGraphQL(t, newCookieClient(t), url, mutation, map[string]interface{}{"email": "unlucky@example.com"})
+	unknown := postGraphQL(t, newCookieClient(t), url, mutation, map[string]interface{}{"email": "nobody-unlucky@example.com"})
+	assert.JSONEq(t, string(unknown), string(registered))
+	assert.Contains(t, string(registered), `"success":true`)
+}
+
+func TestResendVerificationEmailHidesSendFailure(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	// createLoginUser で作るユーザーはメールアドレスの確認が済んでいない
+	createLoginUser(t, gormDB, 241, "Unconfirmed", "unconfirmed-resend@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{241}).Delete(&database.EmailVerificationToken{})
+		gormDB.Where("id IN ?", []uint{241}).Delete(&database.User{})
+	}()
+
+	ts := httptest.NewServer(newGraphQLHandler(&graph.Resolver{GORMDB: gormDB, Mailer: failingMailer{}}))
+	defer ts.Close()
+	url := ts.URL + `/query`
+
+	mutation := `
+		mutation Resend($email: String!) {
+			resendVerificationEmail(email: $email) { success message }
+		}`
+
+	// 送信に失敗しても、登録されていないメールアドレスと同じレスポンスを返す
+	unverified := postGraphQL(t, newCookieClient(t), url, mutation, map[string]interface{}{"email": "unconfirmed-resend@example.com"})
+	unknown := postGraphQL(t, newCookieClient(t), url, mutation, map[string]interface{}{"email": "nobody-resend@example.com"})
+	assert.JSONEq(t, string(unknown), string(unverified))
+	assert.Contains(t, string(unverified), `"success":true`)
+}
+
+func TestTodosPageRefusesDisabledUser(t *testing.T) {
+	config := database.GetDBConfig("test")
+	gormDB, err := database.ConnectGORM(config)
+	if err != nil {
+		t.Fatalf("GORM接続に失敗: %v", err)
+	}
+
+	createLoginUser(t, gormDB, 240, "Page Viewer", "todos-page@example.com", "password")
+
+	defer func() {
+		gormDB.Where("user_id IN ?", []uint{240}).Delete(&database.Session{})
+		gormDB.Where("id IN ?", []uint{240}).Delete(&database.User{})
+	}()
+
+	sessionStore := database.NewSessionStore(gormDB, []byte("test-session-secret"))
+	todoHandler, err := handlers.NewTodoHandler(gormDB, "test", templatesFS, sessionStore)
+	if err != nil {
+		t.Fatalf("TODOハンドラーの初期化に失敗: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/query", newGraphQLHandler(&graph.Resolver{GORMDB: gormDB, SessionStore: sessionStore}))
+	mux.HandleFunc("/todos", todoHandler.ShowTodosPage)
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	client := newCookieClient(t)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	loginAs(t, client, app.URL+`/query`, "todos-page@example.com", "password")
+
+	showTodos := func() *http.Response {
+		t.Helper()
+		resp, err := client.Get(app.URL + "/todos")
+		if err != nil {
+			t.Fatalf("TODOページの取得に失敗: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, showTodos().StatusCode)
+
+	// 無効化されたユーザーのセッションではログインページに戻す
+	gormDB.Model(&database.User{}).Where("id = ?", 240).Update("disabled_at", time.Now())
+	resp := showTodos()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/login", resp.Header.Get("Location"))
+
+	// 削除されたユーザーのセッションも同様
+	gormDB.Where("id IN ?", []uint{240}).Delete(&database.User{})
+	resp = showTodos()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/login", resp.Header.Get("Location"))
+}
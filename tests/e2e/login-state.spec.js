@@ -0,0 +1,49 @@
+const { test, expect } = require('@playwright/test');
+
+const ME_QUERY = 'query Me { me { id name email } }';
+
+test.describe('Login State via me Query', () => {
+  test('should return null for anonymous requests', async ({ request }) => {
+    const response = await request.post('/query', {
+      data: { query: ME_QUERY }
+    });
+    expect(response.ok()).toBeTruthy();
+
+    const body = await response.json();
+    expect(body.errors).toBeUndefined();
+    expect(body.data.me).toBeNull();
+  });
+
+  test('should return the logged in user after loginUser', async ({ request }) => {
+    const timestamp = Date.now();
+    const input = {
+      name: `Me Test ${timestamp}`,
+      email: `me${timestamp}@example.com`,
+      password: 'password123'
+    };
+
+    const registerResponse = await request.post('/query', {
+      data: {
+        query: 'mutation RegisterUser($input: RegisterUserInput!) { registerUser(input: $input) { success } }',
+        variables: { input }
+      }
+    });
+    expect((await registerResponse.json()).data.registerUser.success).toBe(true);
+
+    const loginResponse = await request.post('/query', {
+      data: {
+        query: 'mutation LoginUser($input: LoginUserInput!) { loginUser(input: $input) { success } }',
+        variables: { input: { email: input.email, password: input.password } }
+      }
+    });
+    expect((await loginResponse.json()).data.loginUser.success).toBe(true);
+
+    // ログイン後はセッションCookieによりmeが自分を返す
+    const meResponse = await request.post('/query', {
+      data: { query: ME_QUERY }
+    });
+    const body = await meResponse.json();
+    expect(body.data.me.name).toBe(input.name);
+    expect(body.data.me.email).toBe(input.email);
+  });
+});